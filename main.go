@@ -1,27 +1,109 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"log"
 	"net/http"
 
 	"github.com/eckyputrady/jsonapicrudexample/model"
 	"github.com/eckyputrady/jsonapicrudexample/resource"
 	"github.com/eckyputrady/jsonapicrudexample/storage"
-	"github.com/julienschmidt/httprouter"
 	"github.com/manyminds/api2go"
 )
 
 func main() {
+	backend := flag.String("backend", "memory", "storage backend: memory, journal, sqlite or bolt")
+	dbPath := flag.String("db", "jsonapicrudexample.db", "database file (or directory for journal) used by persistent backends")
+	fsync := flag.String("fsync", "always", "journal fsync policy: always, interval or never")
+	buildingDelete := flag.String("building-delete", "keep", "what happens to the floors of a deleted building: keep, cascade or restrict")
+	floorDelete := flag.String("floor-delete", "restrict", "what happens to buildings referencing a deleted floor: restrict, unlink or cascade")
+	maxPageSize := flag.Int("max-page-size", resource.DefaultMaxPageSize, "largest page[size] and page[limit] clients may ask for")
+	flag.Parse()
+
 	port := 31415
 	host := "localhost"
-	api := api2go.NewAPIWithBaseURL("v0", fmt.Sprintf("http://%s:%d", host, port))
+	baseURL := fmt.Sprintf("http://%s:%d", host, port)
+	api := api2go.NewAPIWithBaseURL("v0", baseURL)
+
+	var (
+		buildingStorage storage.BuildingRepository
+		floorStorage    storage.FloorRepository
+		roomStorage     storage.RoomRepository
+	)
+	switch *backend {
+	case "memory":
+		buildingStorage = storage.NewBuildingStorage()
+		floorStorage = storage.NewFloorStorage()
+		roomStorage = storage.NewRoomStorage()
+	case "journal":
+		opts := storage.JournalOptions{Dir: *dbPath}
+		switch *fsync {
+		case "always":
+			opts.Sync = storage.SyncAlways
+		case "interval":
+			opts.Sync = storage.SyncInterval
+		case "never":
+			opts.Sync = storage.SyncNever
+		default:
+			log.Fatalf("unknown fsync policy %q", *fsync)
+		}
+
+		buildings, err := storage.NewBuildingStorageWithJournal(opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer buildings.Close()
+		floors, err := storage.NewFloorStorageWithJournal(opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer floors.Close()
+		rooms, err := storage.NewRoomStorageWithJournal(opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer rooms.Close()
+		buildingStorage = buildings
+		floorStorage = floors
+		roomStorage = rooms
+	case "sqlite":
+		db, err := storage.OpenSQLite(*dbPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer db.Close()
+		buildingStorage = storage.NewSQLBuildingStorage(db)
+		floorStorage = storage.NewSQLFloorStorage(db)
+		roomStorage = storage.NewSQLRoomStorage(db)
+	case "bolt":
+		db, err := storage.OpenBolt(*dbPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer db.Close()
+		buildingStorage = storage.NewBoltBuildingStorage(db)
+		floorStorage = storage.NewBoltFloorStorage(db)
+		roomStorage = storage.NewBoltRoomStorage(db)
+	default:
+		log.Fatalf("unknown backend %q", *backend)
+	}
+
+	buildingDeletePolicy, err := resource.ParseBuildingDeletePolicy(*buildingDelete)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	floorDeletePolicy, err := resource.ParseFloorDeletePolicy(*floorDelete)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	buildingStorage := storage.NewBuildingStorage()
-	floorStorage := storage.NewFloorStorage()
-	api.AddResource(model.Building{}, resource.BuildingResource{FloorStorage: floorStorage, BuildingStorage: buildingStorage})
-	api.AddResource(model.Floor{}, resource.FloorResource{FloorStorage: floorStorage, BuildingStorage: buildingStorage})
+	api.AddResource(model.Building{}, resource.BuildingResource{FloorStorage: floorStorage, BuildingStorage: buildingStorage, RoomStorage: roomStorage, DeletePolicy: buildingDeletePolicy, MaxPageSize: *maxPageSize})
+	api.AddResource(model.Floor{}, resource.FloorResource{FloorStorage: floorStorage, BuildingStorage: buildingStorage, RoomStorage: roomStorage, DeletePolicy: floorDeletePolicy, MaxPageSize: *maxPageSize})
+	api.AddResource(model.Room{}, resource.RoomResource{RoomStorage: roomStorage, FloorStorage: floorStorage})
 
-	handler := api.Handler().(*httprouter.Router)
+	handler := resource.Handler(api.Handler(), baseURL)
 	fmt.Printf("Listening on %s:%d", host, port)
 	http.ListenAndServe(fmt.Sprintf(":%d", port), handler)
 }
@@ -1,9 +1,13 @@
 package main_test
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"time"
 
 	"github.com/eckyputrady/jsonapicrudexample/model"
 	"github.com/eckyputrady/jsonapicrudexample/resource"
@@ -17,19 +21,40 @@ import (
 // environment. That is because we run all the specs randomized.
 var _ = Describe("CrudExample", func() {
 	var (
-		rec *httptest.ResponseRecorder
-		api *api2go.API
+		rec             *httptest.ResponseRecorder
+		api             *api2go.API
+		buildingStorage *storage.BuildingStorage
+		floorStorage    *storage.FloorStorage
+		roomStorage     *storage.RoomStorage
+		now             func() time.Time
 	)
 
-	BeforeEach(func() {
+	var setupAPI = func(buildingDeletePolicy resource.BuildingDeletePolicy, floorDeletePolicy resource.FloorDeletePolicy) {
 		api = api2go.NewAPIWithBaseURL("v0", "http://localhost:31415")
-		buildingStorage := storage.NewBuildingStorage()
-		floorStorage := storage.NewFloorStorage()
-		api.AddResource(model.Building{}, resource.BuildingResource{FloorStorage: floorStorage, BuildingStorage: buildingStorage})
-		api.AddResource(model.Floor{}, resource.FloorResource{FloorStorage: floorStorage, BuildingStorage: buildingStorage})
+		api.AddResource(model.Building{}, resource.BuildingResource{FloorStorage: floorStorage, BuildingStorage: buildingStorage, RoomStorage: roomStorage, DeletePolicy: buildingDeletePolicy})
+		api.AddResource(model.Floor{}, resource.FloorResource{FloorStorage: floorStorage, BuildingStorage: buildingStorage, RoomStorage: roomStorage, DeletePolicy: floorDeletePolicy})
+		api.AddResource(model.Room{}, resource.RoomResource{RoomStorage: roomStorage, FloorStorage: floorStorage})
+	}
+
+	// handler wraps the api like main does
+	var handler = func() http.Handler {
+		return resource.Handler(api.Handler(), "http://localhost:31415")
+	}
+
+	BeforeEach(func() {
+		now = storage.Now
+		storage.Now = func() time.Time { return time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC) }
+		buildingStorage = storage.NewBuildingStorage()
+		floorStorage = storage.NewFloorStorage()
+		roomStorage = storage.NewRoomStorage()
+		setupAPI(resource.BuildingDeleteKeep, resource.FloorDeleteRestrict)
 		rec = httptest.NewRecorder()
 	})
 
+	AfterEach(func() {
+		storage.Now = now
+	})
+
 	var createBuilding = func() {
 		rec = httptest.NewRecorder()
 		req, err := http.NewRequest("POST", "/v0/buildings", strings.NewReader(`
@@ -43,7 +68,7 @@ var _ = Describe("CrudExample", func() {
 		}
 		`))
 		Expect(err).ToNot(HaveOccurred())
-		api.Handler().ServeHTTP(rec, req)
+		handler().ServeHTTP(rec, req)
 		Expect(rec.Code).To(Equal(http.StatusCreated))
 		Expect(rec.Body.String()).To(MatchJSON(`
 		{
@@ -51,7 +76,9 @@ var _ = Describe("CrudExample", func() {
 				"id": "1",
 				"type": "buildings",
 				"attributes": {
-					"address": "Jurong East"
+					"address": "Jurong East",
+					"createdAt": "2016-01-02T03:04:05Z",
+					"updatedAt": "2016-01-02T03:04:05Z"
 				},
 				"relationships": {
 					"floors": {
@@ -62,7 +89,8 @@ var _ = Describe("CrudExample", func() {
 						}
 					}
 				}
-			}
+			},
+			"meta": {"version": 1}
 		}
 		`))
 	}
@@ -87,7 +115,7 @@ var _ = Describe("CrudExample", func() {
 		}
 		`))
 		Expect(err).ToNot(HaveOccurred())
-		api.Handler().ServeHTTP(rec, req)
+		handler().ServeHTTP(rec, req)
 		Expect(rec.Code).To(Equal(http.StatusCreated))
 
 		// Pagination
@@ -103,20 +131,28 @@ var _ = Describe("CrudExample", func() {
 		}
 		`))
 		Expect(err).ToNot(HaveOccurred())
-		api.Handler().ServeHTTP(rec, req)
+		handler().ServeHTTP(rec, req)
 		Expect(rec.Code).To(Equal(http.StatusOK))
 		Expect(rec.Body.String()).To(MatchJSON(`
 		{
 			"links": {
 				"first": "http://localhost:31415/v0/buildings?page[limit]=1&page[offset]=0",
-				"prev": "http://localhost:31415/v0/buildings?page[limit]=1&page[offset]=0"
+				"prev": "http://localhost:31415/v0/buildings?page[limit]=1&page[offset]=0",
+				"self": "http://localhost:31415/v0/buildings?page[limit]=1&page[offset]=1"
+			},
+			"meta": {
+				"total": 2,
+				"page": 2,
+				"pages": 2
 			},
 			"data": [
 				{
 					"type": "buildings",
 					"id": "2",
 					"attributes": {
-						"address": "Jurong West"
+						"address": "Jurong West",
+						"createdAt": "2016-01-02T03:04:05Z",
+						"updatedAt": "2016-01-02T03:04:05Z"
 					},
 					"relationships": {
 						"floors": {
@@ -146,7 +182,7 @@ var _ = Describe("CrudExample", func() {
 		}
 		`))
 		Expect(err).ToNot(HaveOccurred())
-		api.Handler().ServeHTTP(rec, req)
+		handler().ServeHTTP(rec, req)
 		Expect(rec.Code).To(Equal(http.StatusCreated))
 		Expect(rec.Body.String()).To(MatchJSON(`
 		{
@@ -155,6 +191,22 @@ var _ = Describe("CrudExample", func() {
 				"type": "floors",
 				"attributes": {
 					"name": "B2"
+				},
+				"relationships": {
+					"building": {
+						"data": null,
+						"links": {
+							"related": "http://localhost:31415/v0/floors/1/building",
+							"self": "http://localhost:31415/v0/floors/1/relationships/building"
+						}
+					},
+					"rooms": {
+						"data": [],
+						"links": {
+							"related": "http://localhost:31415/v0/floors/1/rooms",
+							"self": "http://localhost:31415/v0/floors/1/relationships/rooms"
+						}
+					}
 				}
 			}
 		}
@@ -178,18 +230,20 @@ var _ = Describe("CrudExample", func() {
 		}
 		`))
 		Expect(err).ToNot(HaveOccurred())
-		api.Handler().ServeHTTP(rec, req)
+		handler().ServeHTTP(rec, req)
 		Expect(rec.Code).To(Equal(http.StatusNoContent))
 
 		rec = httptest.NewRecorder()
-		req, err = http.NewRequest("GET", "/v0/buildings/1", nil)
-		api.Handler().ServeHTTP(rec, req)
+		req, err = http.NewRequest("GET", "/v0/buildings/1?include=floors", nil)
+		handler().ServeHTTP(rec, req)
 		Expect(err).ToNot(HaveOccurred())
 		Expect(rec.Body.String()).To(MatchJSON(`
 		{
 			"data": {
 				"attributes": {
-					"address": "Jurong East"
+					"address": "Jurong East",
+					"createdAt": "2016-01-02T03:04:05Z",
+					"updatedAt": "2016-01-02T03:04:05Z"
 				},
 				"id": "1",
 				"relationships": {
@@ -214,9 +268,29 @@ var _ = Describe("CrudExample", func() {
 						"name": "B2"
 					},
 					"id": "1",
-					"type": "floors"
+					"type": "floors",
+					"relationships": {
+						"building": {
+							"data": {
+								"id": "1",
+								"type": "buildings"
+							},
+							"links": {
+								"related": "http://localhost:31415/v0/floors/1/building",
+								"self": "http://localhost:31415/v0/floors/1/relationships/building"
+							}
+						},
+						"rooms": {
+							"data": [],
+							"links": {
+								"related": "http://localhost:31415/v0/floors/1/rooms",
+								"self": "http://localhost:31415/v0/floors/1/relationships/rooms"
+							}
+						}
+					}
 				}
-			]
+			],
+			"meta": {"version": 2}
 		}
 		`))
 	}
@@ -246,7 +320,7 @@ var _ = Describe("CrudExample", func() {
     }
 		`))
 		Expect(err).ToNot(HaveOccurred())
-		api.Handler().ServeHTTP(rec, req)
+		handler().ServeHTTP(rec, req)
 		Expect(rec.Code).To(Equal(http.StatusCreated))
 		Expect(rec.Body.String()).To(MatchJSON(`
           {
@@ -254,7 +328,9 @@ var _ = Describe("CrudExample", func() {
               "id": "1",
               "type": "buildings",
               "attributes": {
-                "address": "Jurong East"
+                "address": "Jurong East",
+                "createdAt": "2016-01-02T03:04:05Z",
+                "updatedAt": "2016-01-02T03:04:05Z"
               },
               "relationships": {
                 "floors": {
@@ -270,7 +346,8 @@ var _ = Describe("CrudExample", func() {
                   }
                 }
               }
-            }
+            },
+            "meta": {"version": 1}
           }
           `))
 	})
@@ -298,18 +375,20 @@ var _ = Describe("CrudExample", func() {
 		}
 		`))
 		Expect(err).ToNot(HaveOccurred())
-		api.Handler().ServeHTTP(rec, req)
+		handler().ServeHTTP(rec, req)
 		Expect(rec.Code).To(Equal(http.StatusNoContent))
 
 		rec = httptest.NewRecorder()
 		req, err = http.NewRequest("GET", "/v0/buildings/1", nil)
-		api.Handler().ServeHTTP(rec, req)
+		handler().ServeHTTP(rec, req)
 		Expect(err).ToNot(HaveOccurred())
 		Expect(rec.Body.String()).To(MatchJSON(`
 		{
 			"data": {
 				"attributes": {
-					"address": "Jurong East"
+					"address": "Jurong East",
+					"createdAt": "2016-01-02T03:04:05Z",
+					"updatedAt": "2016-01-02T03:04:05Z"
 				},
 				"id": "1",
 				"relationships": {
@@ -322,7 +401,8 @@ var _ = Describe("CrudExample", func() {
 					}
 				},
 				"type": "buildings"
-			}
+			},
+			"meta": {"version": 3}
 		}
 		`))
 	})
@@ -343,20 +423,22 @@ var _ = Describe("CrudExample", func() {
 		}
 		`))
 		Expect(err).ToNot(HaveOccurred())
-		api.Handler().ServeHTTP(rec, req)
+		handler().ServeHTTP(rec, req)
 		Expect(rec.Code).To(Equal(http.StatusNoContent))
 
 		By("Loading the building from the backend, it should have the relationship")
 
 		rec = httptest.NewRecorder()
-		req, err = http.NewRequest("GET", "/v0/buildings/1", nil)
-		api.Handler().ServeHTTP(rec, req)
+		req, err = http.NewRequest("GET", "/v0/buildings/1?include=floors", nil)
+		handler().ServeHTTP(rec, req)
 		Expect(err).ToNot(HaveOccurred())
 		Expect(rec.Body.String()).To(MatchJSON(`
 		{
 			"data": {
 				"attributes": {
-					"address": "Jurong East"
+					"address": "Jurong East",
+					"createdAt": "2016-01-02T03:04:05Z",
+					"updatedAt": "2016-01-02T03:04:05Z"
 				},
 				"id": "1",
 				"relationships": {
@@ -381,13 +463,55 @@ var _ = Describe("CrudExample", func() {
 						"name": "B2"
 					},
 					"id": "1",
-					"type": "floors"
+					"type": "floors",
+					"relationships": {
+						"building": {
+							"data": {
+								"id": "1",
+								"type": "buildings"
+							},
+							"links": {
+								"related": "http://localhost:31415/v0/floors/1/building",
+								"self": "http://localhost:31415/v0/floors/1/relationships/building"
+							}
+						},
+						"rooms": {
+							"data": [],
+							"links": {
+								"related": "http://localhost:31415/v0/floors/1/rooms",
+								"self": "http://localhost:31415/v0/floors/1/relationships/rooms"
+							}
+						}
+					}
 				}
-			]
+			],
+			"meta": {"version": 2}
 		}
 		`))
 	})
 
+	// getIDs fetches a collection and returns the IDs of its resources in order
+	var getIDs = func(url string) []string {
+		rec = httptest.NewRecorder()
+		req, err := http.NewRequest("GET", url, nil)
+		Expect(err).ToNot(HaveOccurred())
+		handler().ServeHTTP(rec, req)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+
+		var body struct {
+			Data []struct {
+				ID string `json:"id"`
+			} `json:"data"`
+		}
+		Expect(json.Unmarshal(rec.Body.Bytes(), &body)).To(Succeed())
+
+		ids := []string{}
+		for _, d := range body.Data {
+			ids = append(ids, d.ID)
+		}
+		return ids
+	}
+
 	Describe("Load floors of a building directly", func() {
 		BeforeEach(func() {
 			createBuilding()
@@ -407,7 +531,7 @@ var _ = Describe("CrudExample", func() {
 			}
 			`))
 			Expect(err).ToNot(HaveOccurred())
-			api.Handler().ServeHTTP(rec, req)
+			handler().ServeHTTP(rec, req)
 			Expect(rec.Code).To(Equal(http.StatusCreated))
 			Expect(rec.Body.String()).To(MatchJSON(`
 			{
@@ -416,6 +540,22 @@ var _ = Describe("CrudExample", func() {
 					"type": "floors",
 					"attributes": {
 						"name": "G"
+					},
+					"relationships": {
+						"building": {
+							"data": null,
+							"links": {
+								"related": "http://localhost:31415/v0/floors/2/building",
+								"self": "http://localhost:31415/v0/floors/2/relationships/building"
+							}
+						},
+						"rooms": {
+							"data": [],
+							"links": {
+								"related": "http://localhost:31415/v0/floors/2/rooms",
+								"self": "http://localhost:31415/v0/floors/2/relationships/rooms"
+							}
+						}
 					}
 				}
 			}
@@ -427,7 +567,7 @@ var _ = Describe("CrudExample", func() {
 		It("There are 2 floors in the datastorage now", func() {
 			req, err := http.NewRequest("GET", "/v0/floors", nil)
 			Expect(err).ToNot(HaveOccurred())
-			api.Handler().ServeHTTP(rec, req)
+			handler().ServeHTTP(rec, req)
 			Expect(rec.Code).To(Equal(http.StatusOK))
 			Expect(rec.Body.String()).To(MatchJSON(`
 			{
@@ -437,14 +577,49 @@ var _ = Describe("CrudExample", func() {
 							"name": "B2"
 						},
 						"id": "1",
-						"type": "floors"
+						"type": "floors",
+						"relationships": {
+							"building": {
+								"data": {
+									"id": "1",
+									"type": "buildings"
+								},
+								"links": {
+									"related": "http://localhost:31415/v0/floors/1/building",
+									"self": "http://localhost:31415/v0/floors/1/relationships/building"
+								}
+							},
+							"rooms": {
+								"data": [],
+								"links": {
+									"related": "http://localhost:31415/v0/floors/1/rooms",
+									"self": "http://localhost:31415/v0/floors/1/relationships/rooms"
+								}
+							}
+						}
 					},
 					{
 						"attributes": {
 							"name": "G"
 						},
 						"id": "2",
-						"type": "floors"
+						"type": "floors",
+						"relationships": {
+							"building": {
+								"data": null,
+								"links": {
+									"related": "http://localhost:31415/v0/floors/2/building",
+									"self": "http://localhost:31415/v0/floors/2/relationships/building"
+								}
+							},
+							"rooms": {
+								"data": [],
+								"links": {
+									"related": "http://localhost:31415/v0/floors/2/rooms",
+									"self": "http://localhost:31415/v0/floors/2/relationships/rooms"
+								}
+							}
+						}
 					}
 				]
 			}
@@ -452,15 +627,17 @@ var _ = Describe("CrudExample", func() {
 		})
 
 		It("The building only has the previously connected floor", func() {
-			req, err := http.NewRequest("GET", "/v0/buildings/1", nil)
+			req, err := http.NewRequest("GET", "/v0/buildings/1?include=floors", nil)
 			Expect(err).ToNot(HaveOccurred())
-			api.Handler().ServeHTTP(rec, req)
+			handler().ServeHTTP(rec, req)
 			Expect(rec.Code).To(Equal(http.StatusOK))
 			Expect(rec.Body.String()).To(MatchJSON(`
 			{
 				"data": {
 					"attributes": {
-						"address": "Jurong East"
+						"address": "Jurong East",
+						"createdAt": "2016-01-02T03:04:05Z",
+						"updatedAt": "2016-01-02T03:04:05Z"
 					},
 					"id": "1",
 					"relationships": {
@@ -485,9 +662,29 @@ var _ = Describe("CrudExample", func() {
 							"name": "B2"
 						},
 						"id": "1",
-						"type": "floors"
+						"type": "floors",
+						"relationships": {
+							"building": {
+								"data": {
+									"id": "1",
+									"type": "buildings"
+								},
+								"links": {
+									"related": "http://localhost:31415/v0/floors/1/building",
+									"self": "http://localhost:31415/v0/floors/1/relationships/building"
+								}
+							},
+							"rooms": {
+								"data": [],
+								"links": {
+									"related": "http://localhost:31415/v0/floors/1/rooms",
+									"self": "http://localhost:31415/v0/floors/1/relationships/rooms"
+								}
+							}
+						}
 					}
-				]
+				],
+				"meta": {"version": 2}
 			}
 			`))
 		})
@@ -495,7 +692,7 @@ var _ = Describe("CrudExample", func() {
 		It("Directly loading the floors", func() {
 			req, err := http.NewRequest("GET", "/v0/buildings/1/floors", nil)
 			Expect(err).ToNot(HaveOccurred())
-			api.Handler().ServeHTTP(rec, req)
+			handler().ServeHTTP(rec, req)
 			Expect(rec.Code).To(Equal(http.StatusOK))
 			Expect(rec.Body.String()).To(MatchJSON(`
 			{
@@ -505,6 +702,25 @@ var _ = Describe("CrudExample", func() {
 					"id": "1",
 					"attributes": {
 						"name": "B2"
+					},
+					"relationships": {
+						"building": {
+							"data": {
+								"id": "1",
+								"type": "buildings"
+							},
+							"links": {
+								"related": "http://localhost:31415/v0/floors/1/building",
+								"self": "http://localhost:31415/v0/floors/1/relationships/building"
+							}
+						},
+						"rooms": {
+							"data": [],
+							"links": {
+								"related": "http://localhost:31415/v0/floors/1/rooms",
+								"self": "http://localhost:31415/v0/floors/1/relationships/rooms"
+							}
+						}
 					}
 				}
 				]
@@ -515,7 +731,7 @@ var _ = Describe("CrudExample", func() {
 		It("The relationship route works too", func() {
 			req, err := http.NewRequest("GET", "/v0/buildings/1/relationships/floors", nil)
 			Expect(err).ToNot(HaveOccurred())
-			api.Handler().ServeHTTP(rec, req)
+			handler().ServeHTTP(rec, req)
 			Expect(rec.Code).To(Equal(http.StatusOK))
 			Expect(rec.Body.String()).To(MatchJSON(`
 			{
@@ -528,9 +744,1423 @@ var _ = Describe("CrudExample", func() {
 				"links": {
 					"related": "http://localhost:31415/v0/buildings/1/floors",
 					"self": "http://localhost:31415/v0/buildings/1/relationships/floors"
+				},
+				"meta": {
+					"version": 2
 				}
 			}
 			`))
 		})
 	})
-})
+
+	Describe("Referential integrity", func() {
+		It("Rejects a building referencing a missing floor", func() {
+			req, err := http.NewRequest("POST", "/v0/buildings", strings.NewReader(`
+			{
+				"data": {
+					"type": "buildings",
+					"attributes": {
+						"address": "Jurong East"
+					},
+					"relationships": {
+						"floors": {
+							"data": [{"type": "floors", "id": "1"}]
+						}
+					}
+				}
+			}
+			`))
+			Expect(err).ToNot(HaveOccurred())
+			handler().ServeHTTP(rec, req)
+			Expect(rec.Code).To(Equal(http.StatusNotFound))
+			Expect(rec.Body.String()).To(ContainSubstring("Floor with id 1 does not exist"))
+			Expect(rec.Body.String()).To(ContainSubstring("/data/relationships/floors"))
+
+			buildings, _ := buildingStorage.GetAll()
+			Expect(buildings).To(BeEmpty())
+		})
+
+		It("Rejects adding a missing floor to a building", func() {
+			createBuilding()
+			rec = httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/v0/buildings/1/relationships/floors", strings.NewReader(`
+			{
+				"data": [{"type": "floors", "id": "42"}]
+			}
+			`))
+			Expect(err).ToNot(HaveOccurred())
+			handler().ServeHTTP(rec, req)
+			Expect(rec.Code).To(Equal(http.StatusNotFound))
+			Expect(rec.Body.String()).To(ContainSubstring("Floor with id 42 does not exist"))
+		})
+
+		Describe("Deleting a referenced floor", func() {
+			var deleteFloor = func(expectedCode int) {
+				rec = httptest.NewRecorder()
+				req, err := http.NewRequest("DELETE", "/v0/floors/1", nil)
+				Expect(err).ToNot(HaveOccurred())
+				handler().ServeHTTP(rec, req)
+				Expect(rec.Code).To(Equal(expectedCode))
+			}
+
+			BeforeEach(func() {
+				createBuilding()
+				createFloor()
+				replaceFloors()
+			})
+
+			It("Refuses to delete a referenced floor by default", func() {
+				deleteFloor(http.StatusConflict)
+				Expect(rec.Body.String()).To(ContainSubstring("Floor with id 1 is still referenced by building 1"))
+
+				_, err := floorStorage.GetOne("1")
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("Unlinks the floor from its buildings", func() {
+				setupAPI(resource.BuildingDeleteKeep, resource.FloorDeleteUnlink)
+				deleteFloor(http.StatusOK)
+
+				building, err := buildingStorage.GetOne("1")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(building.FloorsIDs).To(BeEmpty())
+			})
+
+			It("Deletes the buildings referencing the floor", func() {
+				setupAPI(resource.BuildingDeleteKeep, resource.FloorDeleteCascade)
+				deleteFloor(http.StatusOK)
+
+				_, err := buildingStorage.GetOne("1")
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	Describe("Deleting a building with floors", func() {
+		var deleteBuilding = func(url string, expectedCode int) {
+			rec = httptest.NewRecorder()
+			req, err := http.NewRequest("DELETE", url, nil)
+			Expect(err).ToNot(HaveOccurred())
+			handler().ServeHTTP(rec, req)
+			Expect(rec.Code).To(Equal(expectedCode))
+		}
+
+		BeforeEach(func() {
+			createBuilding()
+			createFloor()
+			replaceFloors()
+		})
+
+		It("Keeps the floors by default", func() {
+			deleteBuilding("/v0/buildings/1", http.StatusNoContent)
+
+			_, err := buildingStorage.GetOne("1")
+			Expect(err).To(HaveOccurred())
+			_, err = floorStorage.GetOne("1")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("Deletes the floors when configured at startup", func() {
+			setupAPI(resource.BuildingDeleteCascade, resource.FloorDeleteRestrict)
+			deleteBuilding("/v0/buildings/1", http.StatusNoContent)
+
+			_, err := floorStorage.GetOne("1")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Refuses to delete when configured at startup", func() {
+			setupAPI(resource.BuildingDeleteRestrict, resource.FloorDeleteRestrict)
+			deleteBuilding("/v0/buildings/1", http.StatusConflict)
+			Expect(rec.Body.String()).To(ContainSubstring("Building with id 1 still has 1 floors"))
+
+			_, err := buildingStorage.GetOne("1")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("Keeps the floors when requested", func() {
+			setupAPI(resource.BuildingDeleteRestrict, resource.FloorDeleteRestrict)
+			deleteBuilding("/v0/buildings/1?floors=keep", http.StatusNoContent)
+
+			_, err := floorStorage.GetOne("1")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("Deletes the floors when requested", func() {
+			deleteBuilding("/v0/buildings/1?floors=cascade", http.StatusNoContent)
+
+			_, err := floorStorage.GetOne("1")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Refuses to delete when requested", func() {
+			deleteBuilding("/v0/buildings/1?floors=restrict", http.StatusConflict)
+
+			_, err := buildingStorage.GetOne("1")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("Does not delete floors shared with another building", func() {
+			floorStorage.Insert(model.Floor{Name: "G"})
+			buildingStorage.Insert(model.Building{Address: "Jurong West", FloorsIDs: []string{"1"}})
+			buildingStorage.Update(model.Building{ID: "1", Address: "Jurong East", FloorsIDs: []string{"1", "2"}})
+
+			deleteBuilding("/v0/buildings/1?floors=cascade", http.StatusNoContent)
+
+			_, err := floorStorage.GetOne("1")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = floorStorage.GetOne("2")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Rejects an unknown policy", func() {
+			deleteBuilding("/v0/buildings/1?floors=bogus", http.StatusBadRequest)
+
+			_, err := buildingStorage.GetOne("1")
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Describe("Floor to building relationship", func() {
+		var patchBuildingOfFloor = func(body string, expectedCode int) {
+			rec = httptest.NewRecorder()
+			req, err := http.NewRequest("PATCH", "/v0/floors/1/relationships/building", strings.NewReader(body))
+			Expect(err).ToNot(HaveOccurred())
+			handler().ServeHTTP(rec, req)
+			Expect(rec.Code).To(Equal(expectedCode))
+		}
+
+		BeforeEach(func() {
+			createBuilding()
+			createFloor()
+		})
+
+		It("Links back to the building after the building got the floor", func() {
+			replaceFloors()
+
+			rec = httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/v0/floors/1/relationships/building", nil)
+			Expect(err).ToNot(HaveOccurred())
+			handler().ServeHTTP(rec, req)
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(MatchJSON(`
+			{
+				"data": {
+					"id": "1",
+					"type": "buildings"
+				},
+				"links": {
+					"related": "http://localhost:31415/v0/floors/1/building",
+					"self": "http://localhost:31415/v0/floors/1/relationships/building"
+				}
+			}
+			`))
+		})
+
+		It("Loads the building of a floor directly", func() {
+			replaceFloors()
+
+			rec = httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/v0/floors/1/building", nil)
+			Expect(err).ToNot(HaveOccurred())
+			handler().ServeHTTP(rec, req)
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(ContainSubstring(`"address":"Jurong East"`))
+		})
+
+		It("Loads only the building of a floor with pagination", func() {
+			replaceFloors()
+			buildingStorage.Insert(model.Building{Address: "Jurong West"})
+			floorStorage.Insert(model.Floor{Name: "G"})
+
+			Expect(getIDs("/v0/floors/1/building?page[number]=1&page[size]=1")).To(Equal([]string{"1"}))
+			Expect(getIDs("/v0/floors/2/building?page[number]=1&page[size]=1")).To(BeEmpty())
+		})
+
+		It("Adds the floor to the building when the relationship is set on the floor", func() {
+			patchBuildingOfFloor(`{"data": {"type": "buildings", "id": "1"}}`, http.StatusNoContent)
+
+			building, _ := buildingStorage.GetOne("1")
+			Expect(building.FloorsIDs).To(Equal([]string{"1"}))
+		})
+
+		It("Removes the floor from the building when the relationship is cleared", func() {
+			replaceFloors()
+			patchBuildingOfFloor(`{"data": null}`, http.StatusNoContent)
+
+			building, _ := buildingStorage.GetOne("1")
+			Expect(building.FloorsIDs).To(BeEmpty())
+		})
+
+		It("Rejects a missing building", func() {
+			patchBuildingOfFloor(`{"data": {"type": "buildings", "id": "42"}}`, http.StatusNotFound)
+			Expect(rec.Body.String()).To(ContainSubstring("/data/relationships/building"))
+		})
+
+		It("Creates a floor inside a building", func() {
+			rec = httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/v0/floors", strings.NewReader(`
+			{
+				"data": {
+					"type": "floors",
+					"attributes": {
+						"name": "G"
+					},
+					"relationships": {
+						"building": {
+							"data": {"type": "buildings", "id": "1"}
+						}
+					}
+				}
+			}
+			`))
+			Expect(err).ToNot(HaveOccurred())
+			handler().ServeHTTP(rec, req)
+			Expect(rec.Code).To(Equal(http.StatusCreated))
+
+			building, _ := buildingStorage.GetOne("1")
+			Expect(building.FloorsIDs).To(Equal([]string{"2"}))
+		})
+
+		It("Moves the floor when it is given another building", func() {
+			replaceFloors()
+			buildingStorage.Insert(model.Building{Address: "Jurong West"})
+			patchBuildingOfFloor(`{"data": {"type": "buildings", "id": "2"}}`, http.StatusNoContent)
+
+			building, _ := buildingStorage.GetOne("1")
+			Expect(building.FloorsIDs).To(BeEmpty())
+			building, _ = buildingStorage.GetOne("2")
+			Expect(building.FloorsIDs).To(Equal([]string{"1"}))
+		})
+
+	})
+
+	Describe("Floor ownership", func() {
+		var sendFloors = func(method string, url string, expectedCode int) {
+			rec = httptest.NewRecorder()
+			req, err := http.NewRequest(method, url, strings.NewReader(`
+			{
+				"data": [{"type": "floors", "id": "1"}]
+			}
+			`))
+			Expect(err).ToNot(HaveOccurred())
+			handler().ServeHTTP(rec, req)
+			Expect(rec.Code).To(Equal(expectedCode))
+		}
+
+		BeforeEach(func() {
+			createBuilding()
+			createFloor()
+			replaceFloors()
+			buildingStorage.Insert(model.Building{Address: "Jurong West"})
+		})
+
+		It("Refuses to create a building with a floor of another building", func() {
+			rec = httptest.NewRecorder()
+			req, err := http.NewRequest("POST", "/v0/buildings", strings.NewReader(`
+			{
+				"data": {
+					"type": "buildings",
+					"attributes": {
+						"address": "Jurong Central"
+					},
+					"relationships": {
+						"floors": {
+							"data": [{"type": "floors", "id": "1"}]
+						}
+					}
+				}
+			}
+			`))
+			Expect(err).ToNot(HaveOccurred())
+			handler().ServeHTTP(rec, req)
+			Expect(rec.Code).To(Equal(http.StatusConflict))
+			Expect(rec.Body.String()).To(ContainSubstring("Floor with id 1 already belongs to building 1"))
+
+			buildings, _ := buildingStorage.GetAll()
+			Expect(buildings).To(HaveLen(2))
+		})
+
+		It("Refuses to replace the floors with a floor of another building", func() {
+			sendFloors("PATCH", "/v0/buildings/2/relationships/floors", http.StatusConflict)
+
+			building, _ := buildingStorage.GetOne("1")
+			Expect(building.FloorsIDs).To(Equal([]string{"1"}))
+		})
+
+		It("Refuses to add a floor of another building", func() {
+			sendFloors("POST", "/v0/buildings/2/relationships/floors", http.StatusConflict)
+
+			building, _ := buildingStorage.GetOne("2")
+			Expect(building.FloorsIDs).To(BeEmpty())
+		})
+
+		It("Still accepts the floors the building already owns", func() {
+			sendFloors("PATCH", "/v0/buildings/1/relationships/floors", http.StatusNoContent)
+		})
+	})
+
+	Describe("Attribute validation", func() {
+		var send = func(method string, url string, body string) {
+			rec = httptest.NewRecorder()
+			req, err := http.NewRequest(method, url, strings.NewReader(body))
+			Expect(err).ToNot(HaveOccurred())
+			handler().ServeHTTP(rec, req)
+		}
+
+		It("Rejects a building without address", func() {
+			send("POST", "/v0/buildings", `
+			{
+				"data": {
+					"type": "buildings",
+					"attributes": {
+						"address": " "
+					}
+				}
+			}
+			`)
+			Expect(rec.Code).To(Equal(http.StatusUnprocessableEntity))
+			Expect(rec.Body.String()).To(MatchJSON(`
+			{
+				"errors": [
+					{
+						"status": "422",
+						"code": "invalid_attribute",
+						"title": "Invalid attribute",
+						"detail": "address must not be blank",
+						"source": {
+							"pointer": "/data/attributes/address"
+						}
+					}
+				]
+			}
+			`))
+
+			buildings, _ := buildingStorage.GetAll()
+			Expect(buildings).To(BeEmpty())
+		})
+
+		It("Rejects a floor without name", func() {
+			send("POST", "/v0/floors", `
+			{
+				"data": {
+					"type": "floors",
+					"attributes": {}
+				}
+			}
+			`)
+			Expect(rec.Code).To(Equal(http.StatusUnprocessableEntity))
+			Expect(rec.Body.String()).To(ContainSubstring("/data/attributes/name"))
+
+			floors, _ := floorStorage.GetAll()
+			Expect(floors).To(BeEmpty())
+		})
+
+		It("Rejects clearing the address of a building", func() {
+			createBuilding()
+			send("PATCH", "/v0/buildings/1", `
+			{
+				"data": {
+					"type": "buildings",
+					"id": "1",
+					"attributes": {
+						"address": ""
+					}
+				}
+			}
+			`)
+			Expect(rec.Code).To(Equal(http.StatusUnprocessableEntity))
+			Expect(rec.Body.String()).To(ContainSubstring("/data/attributes/address"))
+
+			building, _ := buildingStorage.GetOne("1")
+			Expect(building.Address).To(Equal("Jurong East"))
+		})
+	})
+
+	Describe("Error objects", func() {
+		var send = func(method string, url string) {
+			rec = httptest.NewRecorder()
+			req, err := http.NewRequest(method, url, nil)
+			Expect(err).ToNot(HaveOccurred())
+			handler().ServeHTTP(rec, req)
+		}
+
+		It("Returns 404 with a code for a missing floor", func() {
+			send("GET", "/v0/floors/42")
+			Expect(rec.Code).To(Equal(http.StatusNotFound))
+			Expect(rec.Body.String()).To(MatchJSON(`
+			{
+				"errors": [
+					{
+						"status": "404",
+						"code": "not_found",
+						"title": "Not found",
+						"detail": "Floor with id 42 does not exist"
+					}
+				]
+			}
+			`))
+		})
+
+		It("Returns 404 with a code for a missing building", func() {
+			send("GET", "/v0/buildings/42")
+			Expect(rec.Code).To(Equal(http.StatusNotFound))
+			Expect(rec.Body.String()).To(ContainSubstring(`"code":"not_found"`))
+			Expect(rec.Body.String()).To(ContainSubstring("Building with id 42 does not exist"))
+		})
+
+		It("Returns 404 with a code for the floors of a missing building", func() {
+			send("GET", "/v0/buildings/42/floors")
+			Expect(rec.Code).To(Equal(http.StatusNotFound))
+			Expect(rec.Body.String()).To(ContainSubstring(`"code":"not_found"`))
+		})
+
+		It("Returns 409 with a code when a restricted delete fails", func() {
+			createBuilding()
+			createFloor()
+			replaceFloors()
+			send("DELETE", "/v0/buildings/1?floors=restrict")
+			Expect(rec.Code).To(Equal(http.StatusConflict))
+			Expect(rec.Body.String()).To(MatchJSON(`
+			{
+				"errors": [
+					{
+						"status": "409",
+						"code": "conflict",
+						"title": "Conflict",
+						"detail": "Building with id 1 still has 1 floors"
+					}
+				]
+			}
+			`))
+		})
+
+		It("Returns 400 with a code for an unknown delete policy", func() {
+			createBuilding()
+			send("DELETE", "/v0/buildings/1?floors=explode")
+			Expect(rec.Code).To(Equal(http.StatusBadRequest))
+			Expect(rec.Body.String()).To(ContainSubstring(`"code":"bad_request"`))
+		})
+	})
+
+	Describe("Filtering", func() {
+		BeforeEach(func() {
+			floorStorage.Insert(model.Floor{Name: "B1", BuildingID: "1"})
+			floorStorage.Insert(model.Floor{Name: "G", BuildingID: "2"})
+			buildingStorage.Insert(model.Building{Address: "Jurong East", FloorsIDs: []string{"1"}})
+			buildingStorage.Insert(model.Building{Address: "Jurong West", FloorsIDs: []string{"2"}})
+			buildingStorage.Insert(model.Building{Address: "Bedok"})
+		})
+
+		It("Filters buildings by address", func() {
+			Expect(getIDs("/v0/buildings?filter[address]=Bedok")).To(Equal([]string{"3"}))
+			Expect(getIDs("/v0/buildings?filter[address][prefix]=Jurong")).To(Equal([]string{"1", "2"}))
+			Expect(getIDs("/v0/buildings?filter[address][contains]=West")).To(Equal([]string{"2"}))
+		})
+
+		It("Matches addresses containing commas", func() {
+			buildingStorage.Insert(model.Building{Address: "1 Jurong East, Singapore"})
+			Expect(getIDs("/v0/buildings?filter[address]=1%20Jurong%20East,%20Singapore")).To(Equal([]string{"4"}))
+			Expect(getIDs("/v0/buildings?filter[address][contains]=East,%20Sing")).To(Equal([]string{"4"}))
+		})
+
+		It("Filters buildings by floor", func() {
+			Expect(getIDs("/v0/buildings?filter[floors]=2")).To(Equal([]string{"2"}))
+			Expect(getIDs("/v0/buildings?filter[floors]=42")).To(BeEmpty())
+		})
+
+		It("Filters paginated buildings", func() {
+			Expect(getIDs("/v0/buildings?filter[address][prefix]=Jurong&page[number]=2&page[size]=1")).To(Equal([]string{"2"}))
+		})
+
+		It("Filters floors", func() {
+			Expect(getIDs("/v0/floors?filter[name]=G")).To(Equal([]string{"2"}))
+			Expect(getIDs("/v0/floors?filter[building]=1")).To(Equal([]string{"1"}))
+		})
+
+		It("Filters and sorts the floors of a building", func() {
+			floorStorage.Insert(model.Floor{Name: "G", BuildingID: "1"})
+			buildingStorage.Update(model.Building{ID: "1", Address: "Jurong East", FloorsIDs: []string{"1", "3"}})
+
+			Expect(getIDs("/v0/buildings/1/floors?filter[name]=G")).To(Equal([]string{"3"}))
+			Expect(getIDs("/v0/buildings/1/floors?sort=-name")).To(Equal([]string{"3", "1"}))
+			Expect(getIDs("/v0/buildings/1/floors?filter[name]=G&page[number]=1&page[size]=5")).To(Equal([]string{"3"}))
+		})
+
+		It("Rejects unsupported filters", func() {
+			rec = httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/v0/buildings?filter[floors][prefix]=1", nil)
+			Expect(err).ToNot(HaveOccurred())
+			handler().ServeHTTP(rec, req)
+			Expect(rec.Code).To(Equal(http.StatusBadRequest))
+			Expect(rec.Body.String()).To(ContainSubstring("Unsupported filter filter[floors][prefix]"))
+		})
+	})
+
+	Describe("Sorting", func() {
+		BeforeEach(func() {
+			buildingStorage.Insert(model.Building{Address: "Jurong"})
+			buildingStorage.Insert(model.Building{Address: "Bedok"})
+			buildingStorage.Insert(model.Building{Address: "Jurong"})
+			floorStorage.Insert(model.Floor{Name: "G"})
+			floorStorage.Insert(model.Floor{Name: "B1"})
+		})
+
+		It("Sorts buildings by multiple fields", func() {
+			Expect(getIDs("/v0/buildings?sort=address,-id")).To(Equal([]string{"2", "3", "1"}))
+			Expect(getIDs("/v0/buildings?sort=-address")).To(Equal([]string{"1", "3", "2"}))
+		})
+
+		It("Sorts before paginating", func() {
+			Expect(getIDs("/v0/buildings?sort=address&page[offset]=0&page[limit]=1")).To(Equal([]string{"2"}))
+		})
+
+		It("Sorts floors by name", func() {
+			Expect(getIDs("/v0/floors?sort=name")).To(Equal([]string{"2", "1"}))
+		})
+
+		It("Rejects unknown sort fields", func() {
+			rec = httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/v0/buildings?sort=-floors", nil)
+			Expect(err).ToNot(HaveOccurred())
+			handler().ServeHTTP(rec, req)
+			Expect(rec.Code).To(Equal(http.StatusBadRequest))
+			Expect(rec.Body.String()).To(ContainSubstring("Unsupported sort field floors"))
+		})
+	})
+
+	Describe("Cursor pagination", func() {
+		// getPage fetches a page and returns the IDs on it and its links
+		var getPage = func(url string) ([]string, map[string]string) {
+			ids := getIDs(url)
+			var body struct {
+				Links map[string]string `json:"links"`
+			}
+			Expect(json.Unmarshal(rec.Body.Bytes(), &body)).To(Succeed())
+			return ids, body.Links
+		}
+
+		BeforeEach(func() {
+			for _, address := range []string{"E", "D", "C", "B", "A"} {
+				buildingStorage.Insert(model.Building{Address: address})
+			}
+		})
+
+		It("Walks through all buildings with next links", func() {
+			ids, links := getPage("/v0/buildings?page[cursor]=&page[size]=2")
+			Expect(ids).To(Equal([]string{"1", "2"}))
+			Expect(links).ToNot(HaveKey("prev"))
+			Expect(links).To(HaveKeyWithValue("self", "http://localhost:31415/v0/buildings?page[cursor]=&page[size]=2"))
+
+			ids, links = getPage(links["next"])
+			Expect(ids).To(Equal([]string{"3", "4"}))
+
+			ids, links = getPage(links["next"])
+			Expect(ids).To(Equal([]string{"5"}))
+			Expect(links).ToNot(HaveKey("next"))
+
+			ids, _ = getPage(links["prev"])
+			Expect(ids).To(Equal([]string{"3", "4"}))
+		})
+
+		It("Neither skips nor repeats buildings when others are deleted", func() {
+			ids, links := getPage("/v0/buildings?page[after]=&page[size]=2")
+			Expect(ids).To(Equal([]string{"1", "2"}))
+
+			Expect(buildingStorage.Delete("1")).To(Succeed())
+			Expect(buildingStorage.Delete("3")).To(Succeed())
+
+			ids, _ = getPage(links["next"])
+			Expect(ids).To(Equal([]string{"4", "5"}))
+		})
+
+		It("Follows the sort order", func() {
+			ids, links := getPage("/v0/buildings?sort=address&page[after]=&page[size]=3")
+			Expect(ids).To(Equal([]string{"5", "4", "3"}))
+			Expect(links["next"]).To(ContainSubstring("sort=address"))
+
+			ids, _ = getPage(links["next"])
+			Expect(ids).To(Equal([]string{"2", "1"}))
+		})
+
+		It("Follows a sort order on several fields", func() {
+			buildingStorage.Insert(model.Building{Address: "A"})
+			buildingStorage.Insert(model.Building{Address: "A"})
+
+			ids, links := getPage("/v0/buildings?sort=address,-id&page[after]=&page[size]=2")
+			Expect(ids).To(Equal([]string{"7", "6"}))
+
+			ids, _ = getPage(links["next"])
+			Expect(ids).To(Equal([]string{"5", "4"}))
+		})
+
+		It("Rejects a cursor of another sort order", func() {
+			_, links := getPage("/v0/buildings?sort=address&page[after]=&page[size]=3")
+			rec = httptest.NewRecorder()
+			req, err := http.NewRequest("GET", strings.Replace(links["next"], "sort=address", "sort=-address", 1), nil)
+			Expect(err).ToNot(HaveOccurred())
+			handler().ServeHTTP(rec, req)
+			Expect(rec.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("Rejects a cursor with a position not fitting the sort keys", func() {
+			cursor := base64.RawURLEncoding.EncodeToString([]byte(`{"s":"","p":["A","1"]}`))
+			rec = httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/v0/buildings?page[after]="+cursor, nil)
+			Expect(err).ToNot(HaveOccurred())
+			handler().ServeHTTP(rec, req)
+			Expect(rec.Code).To(Equal(http.StatusBadRequest))
+			Expect(rec.Body.String()).To(ContainSubstring(`"parameter":"page[after]"`))
+		})
+	})
+
+	Describe("Paginating floors", func() {
+		var getMeta = func() (map[string]interface{}, map[string]string) {
+			var body struct {
+				Meta  map[string]interface{} `json:"meta"`
+				Links map[string]string      `json:"links"`
+			}
+			Expect(json.Unmarshal(rec.Body.Bytes(), &body)).To(Succeed())
+			return body.Meta, body.Links
+		}
+
+		BeforeEach(func() {
+			for _, name := range []string{"B2", "B1", "G", "1", "2"} {
+				floorStorage.Insert(model.Floor{Name: name})
+			}
+		})
+
+		It("Paginates with page number and size", func() {
+			Expect(getIDs("/v0/floors?page[number]=2&page[size]=2")).To(Equal([]string{"3", "4"}))
+			meta, links := getMeta()
+			Expect(meta).To(HaveKeyWithValue("total", float64(5)))
+			Expect(links).To(HaveKey("first"))
+			Expect(links).To(HaveKey("prev"))
+			Expect(links).To(HaveKey("next"))
+			Expect(links).To(HaveKey("last"))
+			Expect(links).To(HaveKeyWithValue("self", "http://localhost:31415/v0/floors?page[number]=2&page[size]=2"))
+		})
+
+		It("Paginates with offset and limit", func() {
+			Expect(getIDs("/v0/floors?page[offset]=4&page[limit]=2")).To(Equal([]string{"5"}))
+			meta, links := getMeta()
+			Expect(meta).To(HaveKeyWithValue("total", float64(5)))
+			Expect(links).To(HaveKeyWithValue("self", "http://localhost:31415/v0/floors?page[offset]=4&page[limit]=2"))
+		})
+
+		It("Links a single page to itself", func() {
+			Expect(getIDs("/v0/floors?page[number]=1&page[size]=10")).To(HaveLen(5))
+			_, links := getMeta()
+			Expect(links).To(Equal(map[string]string{"self": "http://localhost:31415/v0/floors?page[number]=1&page[size]=10"}))
+		})
+
+		It("Counts only the filtered floors", func() {
+			Expect(getIDs("/v0/floors?filter[name][prefix]=B&sort=name&page[offset]=0&page[limit]=1")).To(Equal([]string{"2"}))
+			meta, _ := getMeta()
+			Expect(meta).To(HaveKeyWithValue("total", float64(2)))
+		})
+	})
+
+	Describe("Pagination metadata", func() {
+		var getMeta = func(url string) map[string]interface{} {
+			getIDs(url)
+			var body struct {
+				Meta map[string]interface{} `json:"meta"`
+			}
+			Expect(json.Unmarshal(rec.Body.Bytes(), &body)).To(Succeed())
+			return body.Meta
+		}
+
+		var expectBadRequest = func(url string, detail string) {
+			rec = httptest.NewRecorder()
+			req, err := http.NewRequest("GET", url, nil)
+			Expect(err).ToNot(HaveOccurred())
+			handler().ServeHTTP(rec, req)
+			Expect(rec.Code).To(Equal(http.StatusBadRequest))
+			Expect(rec.Body.String()).To(ContainSubstring(detail))
+		}
+
+		BeforeEach(func() {
+			for i := 0; i < 5; i++ {
+				buildingStorage.Insert(model.Building{Address: "Jurong East"})
+			}
+		})
+
+		It("Tells the total, the page and the number of pages", func() {
+			Expect(getMeta("/v0/buildings?page[number]=2&page[size]=2")).To(Equal(map[string]interface{}{
+				"total": float64(5),
+				"page":  float64(2),
+				"pages": float64(3),
+			}))
+			Expect(getMeta("/v0/buildings?page[offset]=4&page[limit]=2")).To(Equal(map[string]interface{}{
+				"total": float64(5),
+				"page":  float64(3),
+				"pages": float64(3),
+			}))
+		})
+
+		It("Rejects pages larger than the maximum page size", func() {
+			expectBadRequest("/v0/buildings?page[number]=1&page[size]=101", "page[size] must not be larger than 100")
+			expectBadRequest("/v0/floors?page[offset]=0&page[limit]=1000", "page[limit] must not be larger than 100")
+			expectBadRequest("/v0/buildings?page[after]=&page[size]=101", "page[size] must not be larger than 100")
+		})
+
+		It("Uses the configured maximum page size", func() {
+			api = api2go.NewAPIWithBaseURL("v0", "http://localhost:31415")
+			api.AddResource(model.Building{}, resource.BuildingResource{FloorStorage: floorStorage, BuildingStorage: buildingStorage, MaxPageSize: 2})
+			expectBadRequest("/v0/buildings?page[number]=1&page[size]=3", "page[size] must not be larger than 2")
+			Expect(getIDs("/v0/buildings?page[number]=1&page[size]=2")).To(HaveLen(2))
+		})
+
+		It("Rejects page params which are not positive numbers", func() {
+			expectBadRequest("/v0/buildings?page[number]=0&page[size]=2", "page[number] must be a number of at least 1")
+			expectBadRequest("/v0/buildings?page[offset]=x&page[limit]=2", "page[offset] must be a number of at least 0")
+		})
+	})
+
+	Describe("Including floors", func() {
+		var get = func(url string) string {
+			rec = httptest.NewRecorder()
+			req, err := http.NewRequest("GET", url, nil)
+			Expect(err).ToNot(HaveOccurred())
+			handler().ServeHTTP(rec, req)
+			return rec.Body.String()
+		}
+
+		BeforeEach(func() {
+			floorStorage.Insert(model.Floor{Name: "B1", BuildingID: "1"})
+			buildingStorage.Insert(model.Building{Address: "Jurong East", FloorsIDs: []string{"1"}})
+		})
+
+		It("Does not include floors unless asked to", func() {
+			Expect(get("/v0/buildings")).ToNot(ContainSubstring("included"))
+			Expect(get("/v0/buildings/1")).ToNot(ContainSubstring("included"))
+			Expect(get("/v0/buildings?page[number]=1&page[size]=1")).ToNot(ContainSubstring("included"))
+		})
+
+		It("Includes floors when asked to", func() {
+			for _, url := range []string{
+				"/v0/buildings?include=floors",
+				"/v0/buildings/1?include=floors",
+				"/v0/buildings?include=floors&page[number]=1&page[size]=1",
+				"/v0/buildings?include=floors&page[after]=",
+			} {
+				var body struct {
+					Included []struct {
+						ID   string `json:"id"`
+						Type string `json:"type"`
+					} `json:"included"`
+				}
+				Expect(json.Unmarshal([]byte(get(url)), &body)).To(Succeed())
+				Expect(rec.Code).To(Equal(http.StatusOK))
+				Expect(body.Included).To(HaveLen(1), url)
+				Expect(body.Included[0].Type).To(Equal("floors"))
+			}
+		})
+
+		It("Includes the floors of every building", func() {
+			floorStorage.Insert(model.Floor{Name: "G", BuildingID: "2"})
+			floorStorage.Insert(model.Floor{Name: "1", BuildingID: "2"})
+			buildingStorage.Insert(model.Building{Address: "Bedok", FloorsIDs: []string{"3", "2"}})
+
+			var body struct {
+				Data []struct {
+					Relationships struct {
+						Floors struct {
+							Data []struct {
+								ID string `json:"id"`
+							} `json:"data"`
+						} `json:"floors"`
+					} `json:"relationships"`
+				} `json:"data"`
+				Included []struct {
+					ID string `json:"id"`
+				} `json:"included"`
+			}
+			Expect(json.Unmarshal([]byte(get("/v0/buildings?include=floors")), &body)).To(Succeed())
+			Expect(body.Data).To(HaveLen(2))
+			Expect(body.Data[1].Relationships.Floors.Data).To(HaveLen(2))
+			Expect(body.Data[1].Relationships.Floors.Data[0].ID).To(Equal("3"))
+			Expect(body.Included).To(HaveLen(3))
+		})
+
+		It("Rejects unknown relationships", func() {
+			Expect(get("/v0/buildings?include=rooms")).To(ContainSubstring("Can not include rooms, buildings has no relationship rooms"))
+			Expect(rec.Code).To(Equal(http.StatusBadRequest))
+
+			get("/v0/buildings/1?include=floors.building")
+			Expect(rec.Code).To(Equal(http.StatusBadRequest))
+
+			get("/v0/floors?include=building")
+			Expect(rec.Code).To(Equal(http.StatusBadRequest))
+
+			Expect(get("/v0/buildings?include=floors,bogus")).To(ContainSubstring("Can not include bogus"))
+			Expect(rec.Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Describe("Sparse fieldsets", func() {
+		type resource struct {
+			ID            string                     `json:"id"`
+			Type          string                     `json:"type"`
+			Attributes    map[string]interface{}     `json:"attributes"`
+			Relationships map[string]json.RawMessage `json:"relationships"`
+		}
+
+		var get = func(url string) (body struct {
+			Data     []resource `json:"data"`
+			Included []resource `json:"included"`
+		}) {
+			rec = httptest.NewRecorder()
+			req, err := http.NewRequest("GET", url, nil)
+			Expect(err).ToNot(HaveOccurred())
+			handler().ServeHTTP(rec, req)
+			Expect(rec.Code).To(Equal(http.StatusOK), url)
+			Expect(json.Unmarshal(rec.Body.Bytes(), &body)).To(Succeed())
+			return body
+		}
+
+		BeforeEach(func() {
+			floorStorage.Insert(model.Floor{Name: "B1", BuildingID: "1"})
+			buildingStorage.Insert(model.Building{Address: "Jurong East", FloorsIDs: []string{"1"}})
+		})
+
+		It("Returns only the selected attributes and relationships", func() {
+			body := get("/v0/buildings?fields[buildings]=address")
+			Expect(body.Data).To(HaveLen(1))
+			Expect(body.Data[0].Type).To(Equal("buildings"))
+			Expect(body.Data[0].Attributes).To(Equal(map[string]interface{}{"address": "Jurong East"}))
+			Expect(body.Data[0].Relationships).ToNot(HaveKey("floors"))
+
+			body = get("/v0/buildings?fields[buildings]=floors&page[number]=1&page[size]=1")
+			Expect(body.Data[0].Attributes).To(BeEmpty())
+			Expect(body.Data[0].Relationships).To(HaveKey("floors"))
+		})
+
+		It("Leaves out selected attributes which are empty", func() {
+			body := get("/v0/buildings?fields[buildings]=latitude,address")
+			Expect(body.Data[0].Attributes).To(Equal(map[string]interface{}{"address": "Jurong East"}))
+
+			body = get("/v0/buildings/1/floors?fields[floors]=building,rooms")
+			Expect(body.Data[0].Attributes).To(BeEmpty())
+			Expect(body.Data[0].Relationships).To(HaveKey("building"))
+		})
+
+		It("Keeps the fields in the pagination links", func() {
+			buildingStorage.Insert(model.Building{Address: "Jurong West"})
+			rec = httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/v0/buildings?fields[buildings]=floors&page[number]=1&page[size]=1", nil)
+			Expect(err).ToNot(HaveOccurred())
+			handler().ServeHTTP(rec, req)
+			Expect(rec.Code).To(Equal(http.StatusOK))
+
+			var body struct {
+				Links map[string]string `json:"links"`
+			}
+			Expect(json.Unmarshal(rec.Body.Bytes(), &body)).To(Succeed())
+			Expect(body.Links["next"]).To(ContainSubstring("fields%5Bbuildings%5D=floors"))
+			Expect(body.Links["self"]).To(Equal("http://localhost:31415/v0/buildings?fields[buildings]=floors&page[number]=1&page[size]=1"))
+		})
+
+		It("Trims included resources to the fields of their type", func() {
+			body := get("/v0/buildings?include=floors&fields[floors]=building")
+			Expect(body.Data[0].Attributes).To(HaveKey("address"))
+			Expect(body.Included).To(HaveLen(1))
+			Expect(body.Included[0].Type).To(Equal("floors"))
+			Expect(body.Included[0].Attributes).To(BeEmpty())
+			Expect(body.Included[0].Relationships).To(HaveKey("building"))
+		})
+
+		It("Returns full resources without the parameter", func() {
+			body := get("/v0/floors")
+			Expect(body.Data[0].Attributes).To(HaveKey("name"))
+			Expect(body.Data[0].Relationships).To(HaveKey("building"))
+		})
+
+		It("Rejects unknown types and fields", func() {
+			for _, url := range []string{
+				"/v0/buildings?fields[widgets]=x",
+				"/v0/buildings/1?fields[buildings]=height",
+				"/v0/floors?fields[floors]=address",
+			} {
+				rec = httptest.NewRecorder()
+				req, err := http.NewRequest("GET", url, nil)
+				Expect(err).ToNot(HaveOccurred())
+				handler().ServeHTTP(rec, req)
+				Expect(rec.Code).To(Equal(http.StatusBadRequest), url)
+			}
+		})
+	})
+
+	Describe("Building attributes", func() {
+		var send = func(method string, url string, body string) {
+			rec = httptest.NewRecorder()
+			req, err := http.NewRequest(method, url, strings.NewReader(body))
+			Expect(err).ToNot(HaveOccurred())
+			handler().ServeHTTP(rec, req)
+		}
+
+		It("Stores name, coordinates and year built and sets the timestamps", func() {
+			send("POST", "/v0/buildings", `
+			{
+				"data": {
+					"type": "buildings",
+					"attributes": {"name": "JEM", "address": "Jurong East", "latitude": 1.333, "longitude": 103.743, "yearBuilt": 2013}
+				}
+			}
+			`)
+			Expect(rec.Code).To(Equal(http.StatusCreated))
+			Expect(rec.Body.String()).To(ContainSubstring(`"createdAt":"2016-01-02T03:04:05Z"`))
+
+			storage.Now = func() time.Time { return time.Date(2016, 2, 3, 4, 5, 6, 0, time.UTC) }
+			send("PATCH", "/v0/buildings/1", `{"data": {"type": "buildings", "id": "1", "attributes": {"name": "JEM Mall"}}}`)
+			Expect(rec.Code).To(Equal(http.StatusNoContent))
+
+			building, err := buildingStorage.GetOne("1")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(building.Name).To(Equal("JEM Mall"))
+			Expect(*building.Latitude).To(Equal(1.333))
+			Expect(*building.YearBuilt).To(Equal(2013))
+			Expect(building.CreatedAt).To(Equal(time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)))
+			Expect(building.UpdatedAt).To(Equal(time.Date(2016, 2, 3, 4, 5, 6, 0, time.UTC)))
+		})
+
+		It("Rejects timestamps sent by the client", func() {
+			send("POST", "/v0/buildings", `
+			{
+				"data": {
+					"type": "buildings",
+					"attributes": {"address": "Jurong East", "createdAt": "2015-01-01T00:00:00Z"}
+				}
+			}
+			`)
+			Expect(rec.Code).To(Equal(http.StatusUnprocessableEntity))
+			Expect(rec.Body.String()).To(ContainSubstring("/data/attributes/createdAt"))
+
+			buildingStorage.Insert(model.Building{Address: "Jurong East"})
+			send("PATCH", "/v0/buildings/1", `{"data": {"type": "buildings", "id": "1", "attributes": {"updatedAt": "2015-01-01T00:00:00Z"}}}`)
+			Expect(rec.Code).To(Equal(http.StatusUnprocessableEntity))
+			Expect(rec.Body.String()).To(ContainSubstring("/data/attributes/updatedAt"))
+		})
+
+		It("Rejects invalid coordinates and years", func() {
+			send("POST", "/v0/buildings", `
+			{
+				"data": {
+					"type": "buildings",
+					"attributes": {"address": "Jurong East", "latitude": 91, "longitude": 0, "yearBuilt": 3000}
+				}
+			}
+			`)
+			Expect(rec.Code).To(Equal(http.StatusUnprocessableEntity))
+			Expect(rec.Body.String()).To(ContainSubstring("/data/attributes/latitude"))
+			Expect(rec.Body.String()).To(ContainSubstring("/data/attributes/yearBuilt"))
+
+			send("POST", "/v0/buildings", `{"data": {"type": "buildings", "attributes": {"address": "Jurong East", "latitude": 1}}}`)
+			Expect(rec.Code).To(Equal(http.StatusUnprocessableEntity))
+		})
+	})
+
+	Describe("Floor levels", func() {
+		var send = func(method string, url string, body string) {
+			rec = httptest.NewRecorder()
+			req, err := http.NewRequest(method, url, strings.NewReader(body))
+			Expect(err).ToNot(HaveOccurred())
+			handler().ServeHTTP(rec, req)
+		}
+
+		var createFloor = func(name string, level int) {
+			send("POST", "/v0/floors", fmt.Sprintf(`
+			{
+				"data": {
+					"type": "floors",
+					"attributes": {"name": %q, "level": %d, "areaSqm": 1250.5},
+					"relationships": {"building": {"data": {"type": "buildings", "id": "1"}}}
+				}
+			}
+			`, name, level))
+		}
+
+		type named struct {
+			Attributes struct {
+				Name string `json:"name"`
+			} `json:"attributes"`
+		}
+
+		var names = func(resources []named) []string {
+			result := []string{}
+			for _, f := range resources {
+				result = append(result, f.Attributes.Name)
+			}
+			return result
+		}
+
+		// floorNames returns the names of the floors in the data of a collection
+		var floorNames = func(url string) []string {
+			send("GET", url, "")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			var body struct {
+				Data []named `json:"data"`
+			}
+			Expect(json.Unmarshal(rec.Body.Bytes(), &body)).To(Succeed())
+			return names(body.Data)
+		}
+
+		// includedNames returns the names of the included resources
+		var includedNames = func(url string) []string {
+			send("GET", url, "")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			var body struct {
+				Included []named `json:"included"`
+			}
+			Expect(json.Unmarshal(rec.Body.Bytes(), &body)).To(Succeed())
+			return names(body.Included)
+		}
+
+		BeforeEach(func() {
+			buildingStorage.Insert(model.Building{Address: "Jurong East"})
+			createFloor("1", 1)
+			createFloor("B1", -1)
+			createFloor("G", 0)
+			Expect(rec.Code).To(Equal(http.StatusCreated))
+		})
+
+		It("Returns the floors of a building ordered by level", func() {
+			Expect(floorNames("/v0/buildings/1/floors")).To(Equal([]string{"B1", "G", "1"}))
+			Expect(includedNames("/v0/buildings/1?include=floors")).To(Equal([]string{"B1", "G", "1"}))
+			Expect(rec.Body.String()).To(ContainSubstring(`"areaSqm":1250.5`))
+		})
+
+		It("Paginates the floors of a building ordered by level", func() {
+			floorStorage.Insert(model.Floor{Name: "Elsewhere"})
+
+			Expect(floorNames("/v0/buildings/1/floors?page[number]=1&page[size]=2")).To(Equal([]string{"B1", "G"}))
+			Expect(floorNames("/v0/buildings/1/floors?page[number]=2&page[size]=2")).To(Equal([]string{"1"}))
+			Expect(floorNames("/v0/buildings/1/floors?page[offset]=0&page[limit]=10&sort=-name")).To(Equal([]string{"G", "B1", "1"}))
+		})
+
+		It("Rejects a second floor on the same level", func() {
+			createFloor("Basement", -1)
+			Expect(rec.Code).To(Equal(http.StatusConflict))
+			Expect(rec.Body.String()).To(ContainSubstring("Floor with id 2 of building 1 is already on level -1"))
+
+			send("PATCH", "/v0/floors/3", `{"data": {"type": "floors", "id": "3", "attributes": {"level": 1}}}`)
+			Expect(rec.Code).To(Equal(http.StatusConflict))
+
+			send("GET", "/v0/floors/3", "")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(ContainSubstring(`"level":0`))
+		})
+
+		It("Replaces a floor by another one on its level", func() {
+			floorStorage.Insert(model.Floor{Name: "Other G", Level: new(int)})
+			send("PATCH", "/v0/buildings/1/relationships/floors", `{"data": [{"type": "floors", "id": "1"}, {"type": "floors", "id": "2"}, {"type": "floors", "id": "4"}]}`)
+			Expect(rec.Code).To(Equal(http.StatusNoContent))
+
+			replaced, _ := floorStorage.GetOne("3")
+			Expect(replaced.BuildingID).To(BeEmpty())
+			replacement, _ := floorStorage.GetOne("4")
+			Expect(replacement.BuildingID).To(Equal("1"))
+		})
+
+		It("Rejects linking floors on the same level to one building", func() {
+			floorStorage.Insert(model.Floor{Name: "Other G", Level: new(int)})
+			send("POST", "/v0/buildings/1/relationships/floors", `{"data": [{"type": "floors", "id": "4"}]}`)
+			Expect(rec.Code).To(Equal(http.StatusConflict))
+			Expect(rec.Body.String()).To(ContainSubstring("Floors with id 3 and 4 are both on level 0"))
+		})
+	})
+
+	Describe("Rooms", func() {
+		var send = func(method string, url string, body string) {
+			rec = httptest.NewRecorder()
+			req, err := http.NewRequest(method, url, strings.NewReader(body))
+			Expect(err).ToNot(HaveOccurred())
+			handler().ServeHTTP(rec, req)
+		}
+
+		var included = func() []string {
+			var body struct {
+				Included []struct {
+					ID   string `json:"id"`
+					Type string `json:"type"`
+				} `json:"included"`
+			}
+			Expect(json.Unmarshal(rec.Body.Bytes(), &body)).To(Succeed())
+
+			result := []string{}
+			for _, res := range body.Included {
+				result = append(result, res.Type+"/"+res.ID)
+			}
+			return result
+		}
+
+		BeforeEach(func() {
+			buildingStorage.Insert(model.Building{Address: "Jurong East", FloorsIDs: []string{"1"}})
+			floorStorage.Insert(model.Floor{Name: "G", BuildingID: "1"})
+			floorStorage.Insert(model.Floor{Name: "1"})
+			send("POST", "/v0/rooms", `{"data": {"type": "rooms", "attributes": {"name": "Lobby"}}}`)
+			Expect(rec.Code).To(Equal(http.StatusCreated))
+			send("POST", "/v0/rooms", `{"data": {"type": "rooms", "attributes": {"name": "Mail room"}}}`)
+			Expect(rec.Code).To(Equal(http.StatusCreated))
+		})
+
+		It("Rejects a room without a name", func() {
+			send("POST", "/v0/rooms", `{"data": {"type": "rooms", "attributes": {"name": " "}}}`)
+			Expect(rec.Code).To(Equal(http.StatusUnprocessableEntity))
+			Expect(rec.Body.String()).To(ContainSubstring("/data/attributes/name"))
+		})
+
+		It("Adds rooms to a floor and includes them with the building", func() {
+			send("POST", "/v0/floors/1/relationships/rooms", `{"data": [{"type": "rooms", "id": "2"}, {"type": "rooms", "id": "1"}]}`)
+			Expect(rec.Code).To(Equal(http.StatusNoContent))
+
+			send("GET", "/v0/floors/1/rooms", "")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(ContainSubstring(`"name":"Mail room"`))
+			Expect(rec.Body.String()).To(ContainSubstring(`"name":"Lobby"`))
+
+			send("GET", "/v0/floors/1?include=rooms", "")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(included()).To(Equal([]string{"rooms/2", "rooms/1"}))
+
+			send("GET", "/v0/buildings/1?include=floors.rooms", "")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(included()).To(ConsistOf("floors/1", "rooms/2", "rooms/1"))
+
+			send("GET", "/v0/buildings?include=floors.beds", "")
+			Expect(rec.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("Rejects unknown rooms and rooms on another floor", func() {
+			send("PATCH", "/v0/floors/1/relationships/rooms", `{"data": [{"type": "rooms", "id": "9"}]}`)
+			Expect(rec.Code).To(Equal(http.StatusNotFound))
+			Expect(rec.Body.String()).To(ContainSubstring("Room with id 9 does not exist"))
+
+			send("PATCH", "/v0/floors/1/relationships/rooms", `{"data": [{"type": "rooms", "id": "1"}]}`)
+			Expect(rec.Code).To(Equal(http.StatusNoContent))
+			send("PATCH", "/v0/floors/2/relationships/rooms", `{"data": [{"type": "rooms", "id": "1"}]}`)
+			Expect(rec.Code).To(Equal(http.StatusConflict))
+			Expect(rec.Body.String()).To(ContainSubstring("Room with id 1 is already on floor 1"))
+		})
+
+		It("Keeps the stored rooms of a floor on a rejected change", func() {
+			floorStorage.Insert(model.Floor{Name: "2", RoomsIDs: []string{"1", "2", "9"}})
+
+			send("DELETE", "/v0/floors/3/relationships/rooms", `{"data": [{"type": "rooms", "id": "1"}, {"type": "rooms", "id": "2"}]}`)
+			Expect(rec.Code).To(Equal(http.StatusNotFound))
+			send("POST", "/v0/floors/3/relationships/rooms", `{"data": [{"type": "rooms", "id": "8"}]}`)
+			Expect(rec.Code).To(Equal(http.StatusNotFound))
+
+			floor, err := floorStorage.GetOne("3")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(floor.RoomsIDs).To(Equal([]string{"1", "2", "9"}))
+		})
+
+		It("Removes a deleted room from its floor", func() {
+			send("PATCH", "/v0/floors/1/relationships/rooms", `{"data": [{"type": "rooms", "id": "1"}, {"type": "rooms", "id": "2"}]}`)
+			Expect(rec.Code).To(Equal(http.StatusNoContent))
+
+			send("DELETE", "/v0/rooms/1", "")
+			Expect(rec.Code).To(Equal(http.StatusNoContent))
+			floor, err := floorStorage.GetOne("1")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(floor.RoomsIDs).To(Equal([]string{"2"}))
+		})
+	})
+
+	Describe("Optimistic concurrency", func() {
+		var send = func(method string, url string, header string, value string, body string) {
+			rec = httptest.NewRecorder()
+			req, err := http.NewRequest(method, url, strings.NewReader(body))
+			Expect(err).ToNot(HaveOccurred())
+			if header != "" {
+				req.Header.Set(header, value)
+			}
+			handler().ServeHTTP(rec, req)
+		}
+
+		var rename = `{"data": {"type": "buildings", "id": "1", "attributes": {"address": "Jurong West"}}}`
+
+		BeforeEach(func() {
+			createBuilding()
+		})
+
+		It("Returns the version as ETag and meta.version", func() {
+			send("GET", "/v0/buildings/1", "", "", "")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Header().Get("ETag")).To(Equal(`"1"`))
+			Expect(rec.Body.String()).To(ContainSubstring(`"meta":{"version":1}`))
+
+			send("PATCH", "/v0/buildings/1", "", "", rename)
+			Expect(rec.Code).To(Equal(http.StatusNoContent))
+			send("GET", "/v0/buildings/1", "", "", "")
+			Expect(rec.Header().Get("ETag")).To(Equal(`"2"`))
+		})
+
+		It("Answers 304 if the building did not change", func() {
+			send("GET", "/v0/buildings/1", "If-None-Match", `"1"`, "")
+			Expect(rec.Code).To(Equal(http.StatusNotModified))
+			Expect(rec.Body.String()).To(BeEmpty())
+
+			send("PATCH", "/v0/buildings/1", "", "", rename)
+			send("GET", "/v0/buildings/1", "If-None-Match", `"1"`, "")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(ContainSubstring("Jurong West"))
+		})
+
+		It("Returns the ETag of written buildings", func() {
+			// the POST of createBuilding
+			Expect(rec.Header().Get("ETag")).To(Equal(`"1"`))
+
+			send("PATCH", "/v0/buildings/1", "", "", rename)
+			Expect(rec.Code).To(Equal(http.StatusNoContent))
+			Expect(rec.Header().Get("ETag")).To(Equal(`"2"`))
+
+			send("PATCH", "/v0/buildings/1", "", "", `{"data": {"type": "buildings", "id": "1", "attributes": {"address": " "}}}`)
+			Expect(rec.Code).To(Equal(http.StatusUnprocessableEntity))
+			Expect(rec.Header().Get("ETag")).To(BeEmpty())
+		})
+
+		It("Compares If-None-Match weakly and If-Match strongly", func() {
+			send("GET", "/v0/buildings/1", "If-None-Match", `W/"1"`, "")
+			Expect(rec.Code).To(Equal(http.StatusNotModified))
+
+			send("PATCH", "/v0/buildings/1", "If-Match", `W/"1"`, rename)
+			Expect(rec.Code).To(Equal(http.StatusPreconditionFailed))
+		})
+
+		It("Answers 412 if the building is written after checking If-Match", func() {
+			api = api2go.NewAPIWithBaseURL("v0", "http://localhost:31415")
+			api.AddResource(model.Building{}, resource.BuildingResource{FloorStorage: floorStorage, BuildingStorage: racingBuildingStorage{buildingStorage}, RoomStorage: roomStorage})
+
+			send("PATCH", "/v0/buildings/1", "If-Match", `"1"`, rename)
+			Expect(rec.Code).To(Equal(http.StatusPreconditionFailed))
+			Expect(rec.Body.String()).To(ContainSubstring(`"code":"precondition_failed"`))
+
+			send("PATCH", "/v0/buildings/1", "", "", rename)
+			Expect(rec.Code).To(Equal(http.StatusConflict))
+			Expect(rec.Body.String()).To(ContainSubstring(`"code":"stale"`))
+		})
+
+		It("Does not delete the building if it is written after checking If-Match", func() {
+			api = api2go.NewAPIWithBaseURL("v0", "http://localhost:31415")
+			api.AddResource(model.Building{}, resource.BuildingResource{FloorStorage: floorStorage, BuildingStorage: racingBuildingStorage{buildingStorage}, RoomStorage: roomStorage})
+
+			send("DELETE", "/v0/buildings/1", "If-Match", `"1"`, "")
+			Expect(rec.Code).To(Equal(http.StatusPreconditionFailed))
+			Expect(rec.Body.String()).To(ContainSubstring(`"code":"precondition_failed"`))
+			_, err := buildingStorage.GetOne("1")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("Has no ETag for collections and included resources", func() {
+			send("GET", "/v0/buildings", "", "", "")
+			Expect(rec.Header().Get("ETag")).To(BeEmpty())
+			send("GET", "/v0/buildings/1?include=floors", "If-None-Match", `"1"`, "")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Header().Get("ETag")).To(BeEmpty())
+		})
+
+		It("Updates only if If-Match names the current version", func() {
+			send("PATCH", "/v0/buildings/1", "If-Match", `"2"`, rename)
+			Expect(rec.Code).To(Equal(http.StatusPreconditionFailed))
+			Expect(rec.Body.String()).To(ContainSubstring(`"code":"precondition_failed"`))
+			building, _ := buildingStorage.GetOne("1")
+			Expect(building.Address).To(Equal("Jurong East"))
+
+			send("PATCH", "/v0/buildings/1", "If-Match", `"1"`, rename)
+			Expect(rec.Code).To(Equal(http.StatusNoContent))
+			building, _ = buildingStorage.GetOne("1")
+			Expect(building.Address).To(Equal("Jurong West"))
+			Expect(building.Version).To(Equal(2))
+		})
+
+		It("Deletes only if If-Match names the current version", func() {
+			send("DELETE", "/v0/buildings/1", "If-Match", `W/"7"`, "")
+			Expect(rec.Code).To(Equal(http.StatusPreconditionFailed))
+
+			send("DELETE", "/v0/buildings/1", "If-Match", `"0", "1"`, "")
+			Expect(rec.Code).To(Equal(http.StatusNoContent))
+		})
+	})
+
+	Describe("Partial updates", func() {
+		var send = func(method string, url string, body string) {
+			rec = httptest.NewRecorder()
+			req, err := http.NewRequest(method, url, strings.NewReader(body))
+			Expect(err).ToNot(HaveOccurred())
+			handler().ServeHTTP(rec, req)
+		}
+
+		var lat, lng, year = 1.333, 103.743, 2013
+
+		BeforeEach(func() {
+			buildingStorage.Insert(model.Building{Name: "JEM", Address: "Jurong East", Latitude: &lat, Longitude: &lng, YearBuilt: &year, FloorsIDs: []string{"1"}})
+			floorStorage.Insert(model.Floor{Name: "G", BuildingID: "1"})
+			floorStorage.Insert(model.Floor{Name: "1"})
+		})
+
+		It("Changes only the attributes in the body", func() {
+			send("PATCH", "/v0/buildings/1", `{"data": {"type": "buildings", "id": "1", "attributes": {"name": "JEM Mall", "yearBuilt": null}}}`)
+			Expect(rec.Code).To(Equal(http.StatusNoContent))
+
+			building, err := buildingStorage.GetOne("1")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(building.Name).To(Equal("JEM Mall"))
+			Expect(building.YearBuilt).To(BeNil())
+			Expect(building.Address).To(Equal("Jurong East"))
+			Expect(*building.Latitude).To(Equal(1.333))
+			Expect(*building.Longitude).To(Equal(103.743))
+			Expect(building.FloorsIDs).To(Equal([]string{"1"}))
+		})
+
+		It("Changes only the relationships in the body", func() {
+			send("PATCH", "/v0/buildings/1", `{"data": {"type": "buildings", "id": "1", "relationships": {"floors": {"data": [{"type": "floors", "id": "2"}]}}}}`)
+			Expect(rec.Code).To(Equal(http.StatusNoContent))
+
+			building, err := buildingStorage.GetOne("1")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(building.FloorsIDs).To(Equal([]string{"2"}))
+			Expect(building.Name).To(Equal("JEM"))
+			Expect(building.Address).To(Equal("Jurong East"))
+			Expect(*building.Latitude).To(Equal(1.333))
+			Expect(*building.YearBuilt).To(Equal(2013))
+
+			floor, _ := floorStorage.GetOne("2")
+			Expect(floor.BuildingID).To(Equal("1"))
+		})
+
+		It("Keeps the stored building when the update is rejected", func() {
+			send("PATCH", "/v0/buildings/1", `{"data": {"type": "buildings", "id": "1", "attributes": {"latitude": 91, "longitude": 0}}}`)
+			Expect(rec.Code).To(Equal(http.StatusUnprocessableEntity))
+
+			building, err := buildingStorage.GetOne("1")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(*building.Latitude).To(Equal(1.333))
+			Expect(*building.Longitude).To(Equal(103.743))
+			Expect(building.Version).To(Equal(1))
+		})
+	})
+})
+
+// racingBuildingStorage writes a building once more right before each Update
+// and delete, like a concurrent request between loading and storing it
+type racingBuildingStorage struct {
+	storage.BuildingRepository
+}
+
+func (s racingBuildingStorage) Update(c model.Building) error {
+	if stored, err := s.BuildingRepository.GetOne(c.ID); err == nil {
+		stored.Version = 0
+		s.BuildingRepository.Update(stored)
+	}
+	return s.BuildingRepository.Update(c)
+}
+
+func (s racingBuildingStorage) DeleteVersion(id string, version int) error {
+	if stored, err := s.BuildingRepository.GetOne(id); err == nil {
+		stored.Version = 0
+		s.BuildingRepository.Update(stored)
+	}
+	return s.BuildingRepository.DeleteVersion(id, version)
+}
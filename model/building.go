@@ -1,18 +1,28 @@
 package model
 
 import (
+	"encoding/json"
 	"errors"
+	"time"
 
 	"github.com/manyminds/api2go/jsonapi"
 )
 
-// Building represents a building
+// Building represents a building. Latitude, Longitude and YearBuilt are
+// optional, CreatedAt, UpdatedAt and Version are maintained by the storage.
 type Building struct {
-	ID string `json:"-"`
-	//rename the username field to user-name.
-	Address   string   `json:"address"`
-	Floors    []Floor  `json:"-"`
-	FloorsIDs []string `json:"-"`
+	ID        string    `json:"-"`
+	Name      string    `json:"name,omitempty"`
+	Address   string    `json:"address"`
+	Latitude  *float64  `json:"latitude,omitempty"`
+	Longitude *float64  `json:"longitude,omitempty"`
+	YearBuilt *int      `json:"yearBuilt,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Floors    []Floor   `json:"-"`
+	FloorsIDs []string  `json:"-"`
+	// Version counts the writes of the building, see storage.BuildingRepository
+	Version int `json:"-"`
 }
 
 // GetID to satisfy jsonapi.MarshalIdentifier interface
@@ -26,6 +36,63 @@ func (u *Building) SetID(id string) error {
 	return nil
 }
 
+// UnmarshalJSON sets the attributes present in data and keeps the others, like
+// a JSON merge patch. Unlike the default decoding it replaces the pointers of u
+// instead of writing through them, they may be shared with a stored building.
+func (u *Building) UnmarshalJSON(data []byte) error {
+	// attributes has the fields of Building without this method
+	type attributes Building
+	var present map[string]json.RawMessage
+	if err := json.Unmarshal(data, &present); err != nil {
+		return err
+	}
+	var patch attributes
+	if err := json.Unmarshal(data, &patch); err != nil {
+		return err
+	}
+
+	for name := range present {
+		switch name {
+		case "name":
+			u.Name = patch.Name
+		case "address":
+			u.Address = patch.Address
+		case "latitude":
+			u.Latitude = patch.Latitude
+		case "longitude":
+			u.Longitude = patch.Longitude
+		case "yearBuilt":
+			u.YearBuilt = patch.YearBuilt
+		case "createdAt":
+			u.CreatedAt = patch.CreatedAt
+		case "updatedAt":
+			u.UpdatedAt = patch.UpdatedAt
+		}
+	}
+	return nil
+}
+
+// Validate to satisfy the Validator interface
+func (u Building) Validate() error {
+	errs := ValidationError{}
+	if isBlank(u.Address) {
+		errs.add("address", "must not be blank")
+	}
+	if (u.Latitude == nil) != (u.Longitude == nil) {
+		errs.add("latitude", "must be given together with longitude")
+	}
+	if u.Latitude != nil && (*u.Latitude < -90 || *u.Latitude > 90) {
+		errs.add("latitude", "must be between -90 and 90")
+	}
+	if u.Longitude != nil && (*u.Longitude < -180 || *u.Longitude > 180) {
+		errs.add("longitude", "must be between -180 and 180")
+	}
+	if u.YearBuilt != nil && (*u.YearBuilt < 1 || *u.YearBuilt > time.Now().Year()) {
+		errs.add("yearBuilt", "must be between 1 and the current year")
+	}
+	return errs.errOrNil()
+}
+
 // GetReferences to satisfy the jsonapi.MarshalReferences interface
 func (u Building) GetReferences() []jsonapi.Reference {
 	return []jsonapi.Reference{
@@ -73,7 +140,7 @@ func (u *Building) SetToManyReferenceIDs(name string, IDs []string) error {
 // AddToManyIDs adds some new floors
 func (u *Building) AddToManyIDs(name string, IDs []string) error {
 	if name == "floors" {
-		u.FloorsIDs = append(u.FloorsIDs, IDs...)
+		u.FloorsIDs = append(append([]string{}, u.FloorsIDs...), IDs...)
 		return nil
 	}
 
@@ -83,14 +150,21 @@ func (u *Building) AddToManyIDs(name string, IDs []string) error {
 // DeleteToManyIDs removes some floors
 func (u *Building) DeleteToManyIDs(name string, IDs []string) error {
 	if name == "floors" {
-		for _, ID := range IDs {
-			for pos, oldID := range u.FloorsIDs {
+		// a new slice, the old one may be shared with the stored building
+		remaining := []string{}
+		for _, oldID := range u.FloorsIDs {
+			obsolete := false
+			for _, ID := range IDs {
 				if ID == oldID {
 					// match, this ID must be removed
-					u.FloorsIDs = append(u.FloorsIDs[:pos], u.FloorsIDs[pos+1:]...)
+					obsolete = true
 				}
 			}
+			if !obsolete {
+				remaining = append(remaining, oldID)
+			}
 		}
+		u.FloorsIDs = remaining
 		return nil
 	}
 
@@ -1,9 +1,22 @@
 package model
 
-// Floor of the building
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/manyminds/api2go/jsonapi"
+)
+
+// Floor of the building. Level counts from the ground floor at 0, basements
+// are negative. A building has at most one floor on each level.
 type Floor struct {
-	ID   string `json:"-"`
-	Name string `json:"name"`
+	ID         string   `json:"-"`
+	Name       string   `json:"name"`
+	Level      *int     `json:"level,omitempty"`
+	AreaSqm    *float64 `json:"areaSqm,omitempty"`
+	BuildingID string   `json:"-"`
+	Rooms      []Room   `json:"-"`
+	RoomsIDs   []string `json:"-"`
 }
 
 // GetID to satisfy jsonapi.MarshalIdentifier interface
@@ -16,3 +29,144 @@ func (c *Floor) SetID(id string) error {
 	c.ID = id
 	return nil
 }
+
+// UnmarshalJSON sets the attributes present in data and keeps the others.
+// Unlike the default decoding it replaces the pointers of c instead of writing
+// through them, they may be shared with a stored floor.
+func (c *Floor) UnmarshalJSON(data []byte) error {
+	// attributes has the fields of Floor without this method
+	type attributes Floor
+	var present map[string]json.RawMessage
+	if err := json.Unmarshal(data, &present); err != nil {
+		return err
+	}
+	var patch attributes
+	if err := json.Unmarshal(data, &patch); err != nil {
+		return err
+	}
+
+	for name := range present {
+		switch name {
+		case "name":
+			c.Name = patch.Name
+		case "level":
+			c.Level = patch.Level
+		case "areaSqm":
+			c.AreaSqm = patch.AreaSqm
+		}
+	}
+	return nil
+}
+
+// Validate to satisfy the Validator interface
+func (c Floor) Validate() error {
+	errs := ValidationError{}
+	if isBlank(c.Name) {
+		errs.add("name", "must not be blank")
+	}
+	if c.AreaSqm != nil && *c.AreaSqm <= 0 {
+		errs.add("areaSqm", "must be positive")
+	}
+	return errs.errOrNil()
+}
+
+// GetReferences to satisfy the jsonapi.MarshalReferences interface
+func (c Floor) GetReferences() []jsonapi.Reference {
+	return []jsonapi.Reference{
+		{
+			Type:         "buildings",
+			Name:         "building",
+			Relationship: jsonapi.ToOneRelationship,
+		},
+		{
+			Type: "rooms",
+			Name: "rooms",
+		},
+	}
+}
+
+// GetReferencedIDs to satisfy the jsonapi.MarshalLinkedRelations interface
+func (c Floor) GetReferencedIDs() []jsonapi.ReferenceID {
+	result := []jsonapi.ReferenceID{}
+	if c.BuildingID != "" {
+		result = append(result, jsonapi.ReferenceID{
+			ID:           c.BuildingID,
+			Type:         "buildings",
+			Name:         "building",
+			Relationship: jsonapi.ToOneRelationship,
+		})
+	}
+	for _, roomID := range c.RoomsIDs {
+		result = append(result, jsonapi.ReferenceID{
+			ID:   roomID,
+			Type: "rooms",
+			Name: "rooms",
+		})
+	}
+
+	return result
+}
+
+// GetReferencedStructs to satisfy the jsonapi.MarhsalIncludedRelations interface
+func (c Floor) GetReferencedStructs() []jsonapi.MarshalIdentifier {
+	result := []jsonapi.MarshalIdentifier{}
+	for key := range c.Rooms {
+		result = append(result, c.Rooms[key])
+	}
+
+	return result
+}
+
+// SetToOneReferenceID sets the building reference ID and satisfies the jsonapi.UnmarshalToOneRelations interface
+func (c *Floor) SetToOneReferenceID(name, ID string) error {
+	if name == "building" {
+		c.BuildingID = ID
+		return nil
+	}
+
+	return errors.New("There is no to-one relationship with the name " + name)
+}
+
+// SetToManyReferenceIDs sets the rooms reference IDs and satisfies the jsonapi.UnmarshalToManyRelations interface
+func (c *Floor) SetToManyReferenceIDs(name string, IDs []string) error {
+	if name == "rooms" {
+		c.RoomsIDs = IDs
+		return nil
+	}
+
+	return errors.New("There is no to-many relationship with the name " + name)
+}
+
+// AddToManyIDs adds some new rooms
+func (c *Floor) AddToManyIDs(name string, IDs []string) error {
+	if name == "rooms" {
+		c.RoomsIDs = append(append([]string{}, c.RoomsIDs...), IDs...)
+		return nil
+	}
+
+	return errors.New("There is no to-many relationship with the name " + name)
+}
+
+// DeleteToManyIDs removes some rooms
+func (c *Floor) DeleteToManyIDs(name string, IDs []string) error {
+	if name == "rooms" {
+		// a new slice, the old one may be shared with the stored floor
+		remaining := []string{}
+		for _, oldID := range c.RoomsIDs {
+			obsolete := false
+			for _, ID := range IDs {
+				if ID == oldID {
+					// match, this ID must be removed
+					obsolete = true
+				}
+			}
+			if !obsolete {
+				remaining = append(remaining, oldID)
+			}
+		}
+		c.RoomsIDs = remaining
+		return nil
+	}
+
+	return errors.New("There is no to-many relationship with the name " + name)
+}
@@ -0,0 +1,27 @@
+package model
+
+// Room on a floor, the floor lists its rooms in Floor.RoomsIDs
+type Room struct {
+	ID   string `json:"-"`
+	Name string `json:"name"`
+}
+
+// GetID to satisfy jsonapi.MarshalIdentifier interface
+func (r Room) GetID() string {
+	return r.ID
+}
+
+// SetID to satisfy jsonapi.UnmarshalIdentifier interface
+func (r *Room) SetID(id string) error {
+	r.ID = id
+	return nil
+}
+
+// Validate to satisfy the Validator interface
+func (r Room) Validate() error {
+	errs := ValidationError{}
+	if isBlank(r.Name) {
+		errs.add("name", "must not be blank")
+	}
+	return errs.errOrNil()
+}
@@ -0,0 +1,45 @@
+package model
+
+import "strings"
+
+// Validator is implemented by models which check their attributes before they are stored
+type Validator interface {
+	Validate() error
+}
+
+// AttributeError describes why a single attribute is invalid
+type AttributeError struct {
+	// Attribute is the json name of the attribute
+	Attribute string
+	Message   string
+}
+
+// ValidationError is returned by Validate and lists every invalid attribute
+type ValidationError struct {
+	Errors []AttributeError
+}
+
+func (e ValidationError) Error() string {
+	messages := []string{}
+	for _, attrErr := range e.Errors {
+		messages = append(messages, attrErr.Attribute+": "+attrErr.Message)
+	}
+	return "Invalid attributes: " + strings.Join(messages, ", ")
+}
+
+// add records an invalid attribute
+func (e *ValidationError) add(attribute string, message string) {
+	e.Errors = append(e.Errors, AttributeError{Attribute: attribute, Message: message})
+}
+
+// errOrNil returns nil if no attribute was invalid
+func (e ValidationError) errOrNil() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+func isBlank(s string) bool {
+	return strings.TrimSpace(s) == ""
+}
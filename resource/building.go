@@ -1,117 +1,512 @@
 package resource
 
 import (
-	"errors"
+	"fmt"
 	"net/http"
-	"strconv"
+	"net/url"
+	"strings"
 
 	"github.com/eckyputrady/jsonapicrudexample/model"
 	"github.com/eckyputrady/jsonapicrudexample/storage"
 	"github.com/manyminds/api2go"
 )
 
+// BuildingDeletePolicy decides what happens to the floors of a deleted building
+type BuildingDeletePolicy int
+
+const (
+	// BuildingDeleteKeep leaves the floors of the building in place
+	BuildingDeleteKeep BuildingDeletePolicy = iota
+	// BuildingDeleteCascade deletes the floors not referenced by any other building
+	BuildingDeleteCascade
+	// BuildingDeleteRestrict refuses to delete a building which still has floors
+	BuildingDeleteRestrict
+)
+
+// ParseBuildingDeletePolicy parses keep, cascade or restrict
+func ParseBuildingDeletePolicy(name string) (BuildingDeletePolicy, error) {
+	switch name {
+	case "keep":
+		return BuildingDeleteKeep, nil
+	case "cascade":
+		return BuildingDeleteCascade, nil
+	case "restrict":
+		return BuildingDeleteRestrict, nil
+	}
+	return BuildingDeleteKeep, fmt.Errorf("unknown building delete policy %q", name)
+}
+
 // BuildingResource for api2go routes
 type BuildingResource struct {
-	FloorStorage    *storage.FloorStorage
-	BuildingStorage *storage.BuildingStorage
+	FloorStorage    storage.FloorRepository
+	BuildingStorage storage.BuildingRepository
+	RoomStorage     storage.RoomRepository
+	// DeletePolicy is used unless the request overrides it with ?floors=keep|cascade|restrict
+	DeletePolicy BuildingDeletePolicy
+	// MaxPageSize limits page[size] and page[limit], 0 means DefaultMaxPageSize
+	MaxPageSize int
 }
 
 // FindAll to satisfy api2go data source interface
 func (s BuildingResource) FindAll(r api2go.Request) (api2go.Responder, error) {
-	buildings := s.BuildingStorage.GetAll()
-	s.includeFloors(toRefSlice(buildings))
-	return &Response{Res: buildings}, nil
+	// set by api2go for /floors/:id/building
+	floorsID, ok := r.QueryParams["floorsID"]
+	if ok {
+		floor, err := s.FloorStorage.GetOne(floorsID[0])
+		if err != nil {
+			return &Response{}, toHTTPError(err)
+		}
+		if floor.BuildingID == "" {
+			return &Response{}, nil
+		}
+
+		return s.FindOne(floor.BuildingID, r)
+	}
+
+	q, fields, err := s.parseQuery(r)
+	if err != nil {
+		return &Response{}, err
+	}
+
+	if isCursorRequest(r) {
+		return s.cursorFindAll(r, q, fields)
+	}
+
+	buildings, err := s.BuildingStorage.Find(q)
+	if err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+
+	err = s.include(r, buildings)
+	return &Response{Res: buildings, Fields: fields}, toHTTPError(err)
 }
 
-func toRefSlice(in []model.Building) []*model.Building {
-	ret := []*model.Building{}
-	for _, b := range in {
-		ret = append(ret, &b)
+// parseQuery returns the storage query for the filter, sort and include
+// params, and the fields asked for with the fields params
+func (s BuildingResource) parseQuery(r api2go.Request) (storage.Query, fieldSet, error) {
+	q, err := parseQuery(r, buildingFilters, storage.BuildingSortFields)
+	if err != nil {
+		return storage.Query{}, nil, err
+	}
+	include, err := parseInclude(r, "buildings")
+	if err != nil {
+		return storage.Query{}, nil, err
 	}
-	return ret
+	fields, err := parseFields(r)
+	if err != nil {
+		return storage.Query{}, nil, err
+	}
+
+	q.Fields = fields.load("buildings", q, include)
+	return q, fields, nil
 }
 
-func (s BuildingResource) includeFloors(buildings []*model.Building) {
-	for _, b := range buildings {
-		b.Floors = s.FloorStorage.GetMany(b.FloorsIDs)
+// cursorFindAll returns the page[size] buildings after the page[after] cursor
+// or before the page[before] cursor, with links to the neighbouring pages
+func (s BuildingResource) cursorFindAll(r api2go.Request, q storage.Query, fields fieldSet) (api2go.Responder, error) {
+	// api2go already splits the params at commas
+	sort := strings.Join(r.QueryParams["sort"], ",")
+	size, sizeExists, err := parsePageParam(r, "page[size]", 1)
+	if err != nil {
+		return &Response{}, err
+	}
+	if !sizeExists {
+		size = 10
+	}
+	if size > maxPageSize(s.MaxPageSize) {
+		return &Response{}, pageSizeError("page[size]", maxPageSize(s.MaxPageSize))
+	}
+
+	afterKey, after := "page[after]", queryParam(r, "page[after]")
+	if after == "" {
+		afterKey, after = "page[cursor]", queryParam(r, "page[cursor]")
+	}
+	before := queryParam(r, "page[before]")
+	if after != "" && before != "" {
+		return &Response{}, badRequest("page[after] and page[before] can not be combined")
+	}
+
+	var buildings []model.Building
+	var hasPrev, hasNext bool
+	if before != "" {
+		pos, err := decodeCursor("page[before]", before, sort, q)
+		if err != nil {
+			return &Response{}, err
+		}
+		q.Before = pos
+
+		n, _, err := s.BuildingStorage.PaginatedFind(q, 0, 0)
+		if err != nil {
+			return &Response{}, toHTTPError(err)
+		}
+		_, buildings, err = s.BuildingStorage.PaginatedFind(q, size, n-size)
+		if err != nil {
+			return &Response{}, toHTTPError(err)
+		}
+		hasPrev, hasNext = n > size, true
+	} else {
+		if after != "" {
+			pos, err := decodeCursor(afterKey, after, sort, q)
+			if err != nil {
+				return &Response{}, err
+			}
+			q.After = pos
+		}
+
+		// one more than asked for tells whether there is a next page
+		_, found, err := s.BuildingStorage.PaginatedFind(q, size+1, 0)
+		if err != nil {
+			return &Response{}, toHTTPError(err)
+		}
+		buildings = found
+		if len(found) > size {
+			buildings = found[:size]
+		}
+		hasPrev, hasNext = after != "", len(found) > size
+	}
+
+	links := map[string]url.Values{}
+	if len(buildings) > 0 {
+		if hasPrev {
+			first := encodeCursor(sort, storage.BuildingPosition(q, buildings[0]))
+			links["prev"] = url.Values{"page[before]": {first}}
+		}
+		if hasNext {
+			last := encodeCursor(sort, storage.BuildingPosition(q, buildings[len(buildings)-1]))
+			links["next"] = url.Values{"page[after]": {last}}
+		}
 	}
+
+	err = s.include(r, buildings)
+	return &Response{Res: buildings, PageLinks: links, Fields: fields}, toHTTPError(err)
 }
 
-func parseUintOrDefault(r api2go.Request, key string, def int) (res int, exists bool) {
-	q, ok := r.QueryParams[key]
-	if !ok {
-		return def, false
+// include loads the relationships asked for with the include param into buildings
+func (s BuildingResource) include(r api2go.Request, buildings []model.Building) error {
+	include, err := parseInclude(r, "buildings")
+	if err != nil {
+		return err
+	}
+	if !include["floors"] {
+		return nil
+	}
+	if err := s.includeFloors(buildings); err != nil {
+		return err
+	}
+	if !include["floors.rooms"] {
+		return nil
+	}
+
+	groups := make([][]model.Floor, len(buildings))
+	for i := range buildings {
+		groups[i] = buildings[i].Floors
+	}
+	return includeRooms(s.RoomStorage, groups...)
+}
+
+// includeFloors loads the floors of all buildings with a single storage call.
+// GetMany keeps the order of the IDs and skips unknown ones, so the floors of
+// each building are a contiguous part of the result. They are ordered by level.
+func (s BuildingResource) includeFloors(buildings []model.Building) error {
+	n := 0
+	for _, b := range buildings {
+		n += len(b.FloorsIDs)
+	}
+	ids := make([]string, 0, n)
+	for _, b := range buildings {
+		ids = append(ids, b.FloorsIDs...)
 	}
 
-	parsed, err := strconv.ParseInt(q[0], 10, 64)
+	floors, err := s.FloorStorage.GetMany(ids)
 	if err != nil {
-		return def, true
+		return err
 	}
 
-	return int(parsed), true
+	next := 0
+	for i := range buildings {
+		start := next
+		for _, id := range buildings[i].FloorsIDs {
+			if next < len(floors) && floors[next].ID == id {
+				next++
+			}
+		}
+		buildings[i].Floors = floors[start:next:next]
+		sortByLevel(buildings[i].Floors)
+	}
+	return nil
 }
 
 // PaginatedFindAll can be used to load buildings in chunks
 func (s BuildingResource) PaginatedFindAll(r api2go.Request) (uint, api2go.Responder, error) {
-	pageNum, pageNumExists := parseUintOrDefault(r, "page[number]", 1)
-	pageSize, pageSizeExists := parseUintOrDefault(r, "page[size]", 10)
-	if pageNumExists && pageSizeExists {
-		n, data := s.BuildingStorage.PaginatedFindAll(pageNum, pageSize)
-		s.includeFloors(toRefSlice(data))
-		return uint(n), &Response{Res: data}, nil
+	q, fields, err := s.parseQuery(r)
+	if err != nil {
+		return 0, &Response{}, err
 	}
 
-	limit, limitExists := parseUintOrDefault(r, "page[limit]", 10)
-	offset, offsetExists := parseUintOrDefault(r, "page[offset]", 0)
-	if limitExists && offsetExists {
-		n, data := s.BuildingStorage.PaginatedFindAllLimitOffset(limit, offset)
-		s.includeFloors(toRefSlice(data))
-		return uint(n), &Response{Res: data}, nil
+	// set by api2go for /floors/:id/building
+	if floorsID, ok := r.QueryParams["floorsID"]; ok {
+		if _, err := s.FloorStorage.GetOne(floorsID[0]); err != nil {
+			return 0, &Response{}, toHTTPError(err)
+		}
+		q.Filters = append(q.Filters, storage.Filter{Field: "floors", Op: storage.FilterEqual, Value: floorsID[0]})
+	}
+
+	p, ok, err := parsePage(r, s.MaxPageSize)
+	if err != nil {
+		return 0, &Response{}, err
+	}
+	if !ok {
+		buildings, err := s.BuildingStorage.Find(q)
+		if err != nil {
+			return 0, &Response{}, toHTTPError(err)
+		}
+		err = s.include(r, buildings)
+		return uint(len(buildings)), &Response{Res: buildings, Fields: fields}, toHTTPError(err)
 	}
 
-	buildings := s.BuildingStorage.GetAll()
-	s.includeFloors(toRefSlice(buildings))
-	return uint(len(buildings)), &Response{Res: buildings}, nil
+	n, data, err := s.BuildingStorage.PaginatedFind(q, p.limit, p.offset)
+	if err != nil {
+		return 0, &Response{}, toHTTPError(err)
+	}
+	err = s.include(r, data)
+	resp := pagedResponse(data, p, n)
+	resp.Fields = fields
+	return uint(n), resp, toHTTPError(err)
 }
 
 // FindOne to satisfy `api2go.DataSource` interface
 func (s BuildingResource) FindOne(ID string, r api2go.Request) (api2go.Responder, error) {
+	fields, err := parseFields(r)
+	if err != nil {
+		return &Response{}, err
+	}
 	building, err := s.BuildingStorage.GetOne(ID)
 	if err != nil {
-		return &Response{}, api2go.NewHTTPError(err, err.Error(), http.StatusNotFound)
+		return &Response{}, toHTTPError(err)
 	}
 
-	building.Floors = s.FloorStorage.GetMany(building.FloorsIDs)
-
-	return &Response{Res: building}, nil
+	buildings := []model.Building{building}
+	if err := s.include(r, buildings); err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+	// api2go loads the building with FindOne for writes too, Update sets their ETag.
+	// Included resources change the response without changing the version.
+	if r.PlainRequest != nil && r.PlainRequest.Method == http.MethodGet && r.QueryParams["include"] == nil {
+		setETag(r, building.Version)
+	}
+	return &Response{Res: buildings[0], Meta: versionMeta(building), Fields: fields}, nil
 }
 
 // Create method to satisfy `api2go.DataSource` interface
 func (s BuildingResource) Create(obj interface{}, r api2go.Request) (api2go.Responder, error) {
 	building, ok := obj.(model.Building)
 	if !ok {
-		return &Response{}, api2go.NewHTTPError(errors.New("Invalid instance given"), "Invalid instance given", http.StatusBadRequest)
+		return &Response{}, badRequest("Invalid instance given")
 	}
 
-	id := s.BuildingStorage.Insert(building)
+	if err := validate(building); err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+	if err := checkTimestamps(building, model.Building{}); err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+	if err := s.checkFloors(building); err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+
+	id, err := s.BuildingStorage.Insert(building)
+	if err != nil {
+		return &Response{}, toHTTPError(err)
+	}
 	building.ID = id
 
-	return &Response{Res: building, Code: http.StatusCreated}, nil
+	if err := linkFloors(s.FloorStorage, nil, building); err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+
+	// the storage sets the timestamps
+	stored, err := s.BuildingStorage.GetOne(id)
+	if err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+	setETag(r, stored.Version)
+	return &Response{Res: stored, Meta: versionMeta(stored), Code: http.StatusCreated}, nil
 }
 
 // Delete to satisfy `api2go.DataSource` interface
 func (s BuildingResource) Delete(id string, r api2go.Request) (api2go.Responder, error) {
-	err := s.BuildingStorage.Delete(id)
-	return &Response{Code: http.StatusNoContent}, err
+	policy := s.DeletePolicy
+	if q, ok := r.QueryParams["floors"]; ok {
+		var err error
+		policy, err = ParseBuildingDeletePolicy(q[0])
+		if err != nil {
+			return &Response{}, badRequest(err.Error())
+		}
+	}
+
+	building, err := s.BuildingStorage.GetOne(id)
+	if err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+	if err := checkIfMatch(r, building); err != nil {
+		return &Response{}, err
+	}
+
+	if policy == BuildingDeleteRestrict && len(building.FloorsIDs) > 0 {
+		err := storage.NewConflictError("Building with id %s still has %d floors", id, len(building.FloorsIDs))
+		return &Response{}, toHTTPError(err)
+	}
+
+	// the storage refuses to delete the building if it was written since it
+	// was checked above
+	if err := s.BuildingStorage.DeleteVersion(id, building.Version); err != nil {
+		return &Response{}, toPreconditionError(r, err)
+	}
+	if err := unlinkFloors(s.FloorStorage, building); err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+
+	if policy == BuildingDeleteCascade {
+		if err := s.deleteOwnedFloors(building); err != nil {
+			return &Response{}, toHTTPError(err)
+		}
+	}
+
+	return &Response{Code: http.StatusNoContent}, nil
+}
+
+// deleteOwnedFloors deletes the floors of a deleted building which no other building references
+func (s BuildingResource) deleteOwnedFloors(building model.Building) error {
+	others, err := s.BuildingStorage.GetAll()
+	if err != nil {
+		return err
+	}
+
+	shared := map[string]bool{}
+	for _, other := range others {
+		for _, floorID := range other.FloorsIDs {
+			shared[floorID] = true
+		}
+	}
+
+	for _, floorID := range building.FloorsIDs {
+		if shared[floorID] {
+			continue
+		}
+		if _, err := s.FloorStorage.GetOne(floorID); err != nil {
+			continue
+		}
+		if err := s.FloorStorage.Delete(floorID); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-//Update stores all changes on the building
+// Update stores the attributes and relationships set by the request, the
+// others keep their stored values. The request was applied to the version
+// api2go loaded with FindOne, see model.Building.UnmarshalJSON, the storage
+// refuses it if the building was written since.
 func (s BuildingResource) Update(obj interface{}, r api2go.Request) (api2go.Responder, error) {
 	building, ok := obj.(model.Building)
 	if !ok {
-		return &Response{}, api2go.NewHTTPError(errors.New("Invalid instance given"), "Invalid instance given", http.StatusBadRequest)
+		return &Response{}, badRequest("Invalid instance given")
 	}
+	if err := checkIfMatch(r, building); err != nil {
+		return &Response{}, err
+	}
+	// floors are loaded by the resource, only the references are stored
+	building.Floors = nil
+
+	old, err := s.BuildingStorage.GetOne(building.ID)
+	if err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+
+	if err := validate(building); err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+	if err := checkTimestamps(building, old); err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+	if err := s.checkFloors(building); err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+
+	if err := s.BuildingStorage.Update(building); err != nil {
+		return &Response{}, toPreconditionError(r, err)
+	}
+
+	if err := linkFloors(s.FloorStorage, old.FloorsIDs, building); err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+
+	// the storage sets the version
+	stored, err := s.BuildingStorage.GetOne(building.ID)
+	if err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+	setETag(r, stored.Version)
+	return &Response{Res: stored, Code: http.StatusNoContent}, nil
+}
 
-	err := s.BuildingStorage.Update(building)
-	return &Response{Res: building, Code: http.StatusNoContent}, err
+// checkFloors returns a 404 error listing every referenced floor that does not exist,
+// or a 409 error listing the referenced floors owned by another building or
+// sharing a level with another referenced floor
+func (s BuildingResource) checkFloors(building model.Building) error {
+	floors, err := s.FloorStorage.GetMany(building.FloorsIDs)
+	if err != nil {
+		return err
+	}
+
+	exists := map[string]bool{}
+	for _, f := range floors {
+		exists[f.ID] = true
+	}
+
+	httpErr := api2go.NewHTTPError(nil, "Referenced floors do not exist", http.StatusNotFound)
+	for _, id := range building.FloorsIDs {
+		if !exists[id] {
+			detail := fmt.Sprintf("Floor with id %s does not exist", id)
+			httpErr.Errors = append(httpErr.Errors, errorObject(http.StatusNotFound, CodeNotFound, "Floor not found", detail, "/data/relationships/floors"))
+		}
+	}
+
+	if len(httpErr.Errors) > 0 {
+		return httpErr
+	}
+
+	// a floor belongs to at most one building, the owner is tracked in Floor.BuildingID
+	httpErr = api2go.NewHTTPError(nil, "Referenced floors belong to another building", http.StatusConflict)
+	for _, f := range floors {
+		if f.BuildingID != "" && f.BuildingID != building.ID {
+			detail := fmt.Sprintf("Floor with id %s already belongs to building %s", f.ID, f.BuildingID)
+			httpErr.Errors = append(httpErr.Errors, errorObject(http.StatusConflict, CodeConflict, "Floor belongs to another building", detail, "/data/relationships/floors"))
+		}
+	}
+
+	if len(httpErr.Errors) > 0 {
+		return httpErr
+	}
+
+	// at most one floor of a building is on each level
+	httpErr = api2go.NewHTTPError(nil, "Referenced floors are on the same level", http.StatusConflict)
+	onLevel := map[int]string{}
+	for _, f := range floors {
+		if f.Level == nil {
+			continue
+		}
+		if other, ok := onLevel[*f.Level]; ok && other != f.ID {
+			detail := fmt.Sprintf("Floors with id %s and %s are both on level %d", other, f.ID, *f.Level)
+			httpErr.Errors = append(httpErr.Errors, errorObject(http.StatusConflict, CodeConflict, "Level already taken", detail, "/data/relationships/floors"))
+			continue
+		}
+		onLevel[*f.Level] = f.ID
+	}
+
+	if len(httpErr.Errors) > 0 {
+		return httpErr
+	}
+	return nil
 }
@@ -0,0 +1,82 @@
+package resource
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/eckyputrady/jsonapicrudexample/model"
+	"github.com/eckyputrady/jsonapicrudexample/storage"
+)
+
+// benchmarkBuildings stores 3 floors for each of 1000 buildings
+func benchmarkBuildings(b *testing.B, floors storage.FloorRepository) []model.Building {
+	buildings := []model.Building{}
+	for i := 1; i <= 1000; i++ {
+		building := model.Building{ID: strconv.Itoa(i)}
+		for j := 0; j < 3; j++ {
+			id, err := floors.Insert(model.Floor{Name: strconv.Itoa(j), BuildingID: building.ID})
+			if err != nil {
+				b.Fatal(err)
+			}
+			building.FloorsIDs = append(building.FloorsIDs, id)
+		}
+		buildings = append(buildings, building)
+	}
+	return buildings
+}
+
+// BenchmarkIncludeFloors compares looking up the floors of every building on
+// its own with the batched lookup of includeFloors
+func BenchmarkIncludeFloors(b *testing.B) {
+	backends := map[string]func(b *testing.B) (storage.FloorRepository, func()){
+		"map": func(b *testing.B) (storage.FloorRepository, func()) {
+			return storage.NewFloorStorage(), func() {}
+		},
+		"sqlite": func(b *testing.B) (storage.FloorRepository, func()) {
+			dir, err := ioutil.TempDir("", "include-bench")
+			if err != nil {
+				b.Fatal(err)
+			}
+			db, err := storage.OpenSQLite(filepath.Join(dir, "bench.db"))
+			if err != nil {
+				b.Fatal(err)
+			}
+			return storage.NewSQLFloorStorage(db), func() {
+				db.Close()
+				os.RemoveAll(dir)
+			}
+		},
+	}
+
+	for name, open := range backends {
+		b.Run(name, func(b *testing.B) {
+			floors, closeFn := open(b)
+			defer closeFn()
+			buildings := benchmarkBuildings(b, floors)
+			s := BuildingResource{FloorStorage: floors}
+
+			b.Run("per building", func(b *testing.B) {
+				for n := 0; n < b.N; n++ {
+					for i := range buildings {
+						found, err := floors.GetMany(buildings[i].FloorsIDs)
+						if err != nil {
+							b.Fatal(err)
+						}
+						buildings[i].Floors = found
+					}
+				}
+			})
+
+			b.Run("batched", func(b *testing.B) {
+				for n := 0; n < b.N; n++ {
+					if err := s.includeFloors(buildings); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package resource
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/eckyputrady/jsonapicrudexample/storage"
+	"github.com/manyminds/api2go"
+)
+
+// cursor is the opaque value of page[after] and page[before]. It pins the
+// position of a record in the order given by the sort param the cursor was
+// created for.
+type cursor struct {
+	Sort     string   `json:"s"`
+	Position []string `json:"p"`
+}
+
+func encodeCursor(sort string, position []string) string {
+	data, _ := json.Marshal(cursor{Sort: sort, Position: position})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor returns the position stored in the value of the param key for
+// the sort keys of q. Malformed cursors and cursors of another sort order are
+// a 400 error pointing at key.
+func decodeCursor(key string, value string, sort string, q storage.Query) ([]string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, pageParamError(key, "Malformed page cursor")
+	}
+
+	c := cursor{}
+	// a position holds the value of every sort key and the ID
+	if err := json.Unmarshal(data, &c); err != nil || len(c.Position) != len(q.Sort)+1 {
+		return nil, pageParamError(key, "Malformed page cursor")
+	}
+	if c.Sort != sort {
+		return nil, pageParamError(key, "The page cursor belongs to another sort order")
+	}
+	return c.Position, nil
+}
+
+// isCursorRequest checks for page[after], its alias page[cursor], or page[before]
+func isCursorRequest(r api2go.Request) bool {
+	for _, key := range []string{"page[after]", "page[cursor]", "page[before]"} {
+		if _, ok := r.QueryParams[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func queryParam(r api2go.Request, key string) string {
+	if values := r.QueryParams[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
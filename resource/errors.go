@@ -0,0 +1,76 @@
+package resource
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/eckyputrady/jsonapicrudexample/storage"
+	"github.com/manyminds/api2go"
+)
+
+// Codes sent in the code member of the error objects, clients can rely on them
+const (
+	CodeNotFound           = "not_found"
+	CodeConflict           = "conflict"
+	CodeStale              = "stale"
+	CodePreconditionFailed = "precondition_failed"
+	CodeInvalid            = "invalid"
+	CodeInvalidAttribute   = "invalid_attribute"
+	CodeBadRequest         = "bad_request"
+	CodeInternal           = "internal_error"
+)
+
+// newError returns an HTTPError holding a single error object. Pointer is
+// left out of the error object if it is empty.
+func newError(err error, status int, code string, title string, detail string, pointer string) api2go.HTTPError {
+	httpErr := api2go.NewHTTPError(err, title, status)
+	httpErr.Errors = []api2go.Error{errorObject(status, code, title, detail, pointer)}
+	return httpErr
+}
+
+func errorObject(status int, code string, title string, detail string, pointer string) api2go.Error {
+	obj := api2go.Error{
+		Status: strconv.Itoa(status),
+		Code:   code,
+		Title:  title,
+		Detail: detail,
+	}
+	if pointer != "" {
+		obj.Source = &api2go.ErrorSource{Pointer: pointer}
+	}
+	return obj
+}
+
+// badRequest is returned for requests api2go accepted but the resource can not handle
+func badRequest(detail string) api2go.HTTPError {
+	return newError(errors.New(detail), http.StatusBadRequest, CodeBadRequest, "Bad request", detail, "")
+}
+
+// toHTTPError maps the errors of the storages to HTTPErrors with a matching
+// status. HTTPErrors are returned as they are, anything else is a failure of
+// the backend and becomes a 500 which does not leak its message.
+func toHTTPError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(api2go.HTTPError); ok {
+		return err
+	}
+
+	storageErr, ok := err.(storage.Error)
+	if !ok {
+		return newError(err, http.StatusInternalServerError, CodeInternal, "Internal server error", "The storage failed to handle the request", "")
+	}
+
+	switch storageErr.Kind {
+	case storage.NotFound:
+		return newError(err, http.StatusNotFound, CodeNotFound, "Not found", err.Error(), "")
+	case storage.Conflict:
+		return newError(err, http.StatusConflict, CodeConflict, "Conflict", err.Error(), "")
+	case storage.Stale:
+		return newError(err, http.StatusConflict, CodeStale, "Conflict", err.Error(), "")
+	default:
+		return newError(err, http.StatusUnprocessableEntity, CodeInvalid, "Invalid resource", err.Error(), "")
+	}
+}
@@ -0,0 +1,103 @@
+package resource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/eckyputrady/jsonapicrudexample/model"
+	"github.com/eckyputrady/jsonapicrudexample/storage"
+	"github.com/manyminds/api2go"
+)
+
+// versionMeta is the top level meta object of a single building, clients send
+// the version back in If-Match to update or delete it conditionally
+func versionMeta(building model.Building) map[string]interface{} {
+	return map[string]interface{}{"version": building.Version}
+}
+
+// entityTag returns the ETag of a version, like "3"
+func entityTag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// matchesETag reports whether the If-Match or If-None-Match header lists
+// etag or is *. The weak comparison of If-None-Match lets W/"3" match "3",
+// the strong one of If-Match never matches a weak tag.
+func matchesETag(header string, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch returns a 412 error if the request has an If-Match header not
+// matching the version of building
+func checkIfMatch(r api2go.Request, building model.Building) error {
+	header := r.Header.Get("If-Match")
+	if header == "" || matchesETag(header, entityTag(building.Version), false) {
+		return nil
+	}
+
+	detail := fmt.Sprintf("Building with id %s is at version %d, If-Match is %s", building.ID, building.Version, header)
+	return newError(errors.New(detail), http.StatusPreconditionFailed, CodePreconditionFailed, "Precondition failed", detail, "")
+}
+
+// toPreconditionError is toHTTPError, except that a Stale error of a request
+// with an If-Match header is a 412 error: the building was written between
+// checkIfMatch and the write
+func toPreconditionError(r api2go.Request, err error) error {
+	if storage.IsStale(err) && r.Header.Get("If-Match") != "" {
+		return newError(err, http.StatusPreconditionFailed, CodePreconditionFailed, "Precondition failed", err.Error(), "")
+	}
+	return toHTTPError(err)
+}
+
+type headerContextKey struct{}
+
+// setETag sets the ETag of version on the response to r, if it went through
+// ETags
+func setETag(r api2go.Request, version int) {
+	if r.PlainRequest == nil {
+		return
+	}
+	if header, ok := r.PlainRequest.Context().Value(headerContextKey{}).(http.Header); ok {
+		header.Set("ETag", entityTag(version))
+	}
+}
+
+// ETags wraps the api2go handler, which can not set headers, to let the
+// resources add an ETag with setETag. Those of buildings do to every GET of a
+// single building and to every write of one. A GET whose If-None-Match matches
+// the ETag is answered with 304 Not Modified and no body.
+func ETags(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req = req.WithContext(context.WithValue(req.Context(), headerContextKey{}, w.Header()))
+		if req.Method != http.MethodGet {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		res := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+		next.ServeHTTP(res, req)
+
+		etag := w.Header().Get("ETag")
+		if res.status == http.StatusOK && etag != "" && matchesETag(req.Header.Get("If-None-Match"), etag, true) {
+			w.Header().Del("Content-Type")
+			w.Header().Del("Content-Length")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		res.flush(w)
+	})
+}
@@ -0,0 +1,224 @@
+package resource
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/eckyputrady/jsonapicrudexample/model"
+	"github.com/eckyputrady/jsonapicrudexample/storage"
+	"github.com/manyminds/api2go"
+	"github.com/manyminds/api2go/jsonapi"
+)
+
+// selectable lists the attributes and relationships of each resource type
+// which can be asked for with fields[type]
+var selectable = map[string][]string{
+	"buildings": storage.BuildingFields,
+	"floors":    storage.FloorFields,
+	"rooms":     storage.RoomFields,
+}
+
+// fieldSet holds the fields asked for with fields[type]=a,b, types without
+// the param are missing and keep all of their fields
+type fieldSet map[string][]string
+
+var fieldsKey = regexp.MustCompile(`^fields\[([^\]]+)\]$`)
+
+// fieldParams returns the fields[type] params Handler took out of the request,
+// or those still in it
+func fieldParams(r api2go.Request) map[string][]string {
+	if r.PlainRequest != nil {
+		if fields, ok := r.PlainRequest.Context().Value(fieldsContextKey{}).(url.Values); ok {
+			return fields
+		}
+	}
+	return r.QueryParams
+}
+
+// parseFields reads the fields[type] params, unknown types and fields are a 400 error
+func parseFields(r api2go.Request) (fieldSet, error) {
+	set := fieldSet{}
+	for key, values := range fieldParams(r) {
+		if !strings.HasPrefix(key, "fields[") {
+			continue
+		}
+
+		m := fieldsKey.FindStringSubmatch(key)
+		if m == nil {
+			return nil, badRequest(fmt.Sprintf("Unsupported parameter %s", key))
+		}
+		typ := m[1]
+		fields, ok := selectable[typ]
+		if !ok {
+			return nil, badRequest(fmt.Sprintf("Can not select fields of unknown type %s", typ))
+		}
+
+		set[typ] = []string{}
+		// api2go already splits the params at commas
+		for _, name := range strings.Split(strings.Join(values, ","), ",") {
+			if name == "" {
+				continue
+			}
+			if !containsString(fields, name) {
+				return nil, badRequest(fmt.Sprintf("Unknown field %s of %s", name, typ))
+			}
+			set[typ] = append(set[typ], name)
+		}
+	}
+	return set, nil
+}
+
+// load returns the fields of typ storage has to load for q, which are the
+// selected ones plus those needed to sort and to include relationships. It
+// is nil if typ has no fields param, so all fields are loaded.
+func (f fieldSet) load(typ string, q storage.Query, include includeSet) []string {
+	selected, ok := f[typ]
+	if !ok {
+		return nil
+	}
+
+	needed := append([]string{}, selected...)
+	for _, k := range q.Sort {
+		needed = append(needed, k.Field)
+	}
+	for path := range include {
+		needed = append(needed, strings.Split(path, ".")[0])
+	}
+
+	result := []string{}
+	for _, name := range needed {
+		if containsString(selectable[typ], name) && !containsString(result, name) {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// sparse wraps res, a resource or a slice of resources, so only the selected
+// fields are serialized. Without fields params res is returned as is.
+func (f fieldSet) sparse(res interface{}) interface{} {
+	if len(f) == 0 || res == nil {
+		return res
+	}
+
+	if v := reflect.ValueOf(res); v.Kind() == reflect.Slice {
+		result := make([]jsonapi.MarshalIdentifier, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			element, ok := v.Index(i).Interface().(jsonapi.MarshalIdentifier)
+			if !ok {
+				return res
+			}
+			result = append(result, f.wrap(element))
+		}
+		return result
+	}
+
+	if element, ok := res.(jsonapi.MarshalIdentifier); ok {
+		return f.wrap(element)
+	}
+	return res
+}
+
+func (f fieldSet) wrap(res jsonapi.MarshalIdentifier) jsonapi.MarshalIdentifier {
+	return sparseResource{MarshalIdentifier: res, typ: resourceType(res), fields: f}
+}
+
+// resourceType returns the JSON API type of res
+func resourceType(res jsonapi.MarshalIdentifier) string {
+	switch res.(type) {
+	case model.Building, *model.Building:
+		return "buildings"
+	case model.Floor, *model.Floor:
+		return "floors"
+	case model.Room, *model.Room:
+		return "rooms"
+	}
+	return ""
+}
+
+// sparseResource serializes the selected attributes and relationships of a
+// resource, its included resources are trimmed to the fields of their type
+type sparseResource struct {
+	jsonapi.MarshalIdentifier
+	typ    string
+	fields fieldSet
+}
+
+// GetName to satisfy the jsonapi.EntityNamer interface
+func (s sparseResource) GetName() string {
+	return s.typ
+}
+
+func (s sparseResource) selected(name string) bool {
+	fields, ok := s.fields[s.typ]
+	return !ok || containsString(fields, name)
+}
+
+// MarshalJSON returns the selected attributes
+func (s sparseResource) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(s.MarshalIdentifier)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &attributes); err != nil {
+		return nil, err
+	}
+	for name := range attributes {
+		if !s.selected(name) {
+			delete(attributes, name)
+		}
+	}
+	return json.Marshal(attributes)
+}
+
+// GetReferences to satisfy the jsonapi.MarshalReferences interface
+func (s sparseResource) GetReferences() []jsonapi.Reference {
+	refs, ok := s.MarshalIdentifier.(jsonapi.MarshalReferences)
+	if !ok {
+		return nil
+	}
+
+	result := []jsonapi.Reference{}
+	for _, ref := range refs.GetReferences() {
+		if s.selected(ref.Name) {
+			result = append(result, ref)
+		}
+	}
+	return result
+}
+
+// GetReferencedIDs to satisfy the jsonapi.MarshalLinkedRelations interface
+func (s sparseResource) GetReferencedIDs() []jsonapi.ReferenceID {
+	refs, ok := s.MarshalIdentifier.(jsonapi.MarshalLinkedRelations)
+	if !ok {
+		return nil
+	}
+
+	result := []jsonapi.ReferenceID{}
+	for _, id := range refs.GetReferencedIDs() {
+		if s.selected(id.Name) {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// GetReferencedStructs to satisfy the jsonapi.MarshalIncludedRelations interface
+func (s sparseResource) GetReferencedStructs() []jsonapi.MarshalIdentifier {
+	included, ok := s.MarshalIdentifier.(jsonapi.MarshalIncludedRelations)
+	if !ok {
+		return nil
+	}
+
+	result := []jsonapi.MarshalIdentifier{}
+	for _, res := range included.GetReferencedStructs() {
+		result = append(result, s.fields.wrap(res))
+	}
+	return result
+}
@@ -2,6 +2,7 @@ package resource
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/eckyputrady/jsonapicrudexample/model"
@@ -9,58 +10,390 @@ import (
 	"github.com/manyminds/api2go"
 )
 
+// FloorDeletePolicy decides what happens to the buildings referencing a deleted floor
+type FloorDeletePolicy int
+
+const (
+	// FloorDeleteRestrict refuses to delete a floor that is still referenced
+	FloorDeleteRestrict FloorDeletePolicy = iota
+	// FloorDeleteUnlink removes the floor from the buildings referencing it
+	FloorDeleteUnlink
+	// FloorDeleteCascade deletes the buildings referencing the floor
+	FloorDeleteCascade
+)
+
+// ParseFloorDeletePolicy parses restrict, unlink or cascade
+func ParseFloorDeletePolicy(name string) (FloorDeletePolicy, error) {
+	switch name {
+	case "restrict":
+		return FloorDeleteRestrict, nil
+	case "unlink":
+		return FloorDeleteUnlink, nil
+	case "cascade":
+		return FloorDeleteCascade, nil
+	}
+	return FloorDeleteRestrict, fmt.Errorf("unknown floor delete policy %q", name)
+}
+
 // FloorResource for api2go routes
 type FloorResource struct {
-	FloorStorage    *storage.FloorStorage
-	BuildingStorage *storage.BuildingStorage
+	FloorStorage    storage.FloorRepository
+	BuildingStorage storage.BuildingRepository
+	RoomStorage     storage.RoomRepository
+	DeletePolicy    FloorDeletePolicy
+	// MaxPageSize limits page[size] and page[limit], 0 means DefaultMaxPageSize
+	MaxPageSize int
 }
 
 // FindAll floors
 func (c FloorResource) FindAll(r api2go.Request) (api2go.Responder, error) {
+	include, err := parseInclude(r, "floors")
+	if err != nil {
+		return &Response{}, err
+	}
+	fields, err := parseFields(r)
+	if err != nil {
+		return &Response{}, err
+	}
+
+	q, byLevel, err := c.parseQuery(r, include, fields)
+	if err != nil {
+		return &Response{}, err
+	}
+
+	floors, err := c.FloorStorage.Find(q)
+	if err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+	if byLevel {
+		sortByLevel(floors)
+	}
+	err = c.include(include, floors)
+	return &Response{Res: floors, Fields: fields}, toHTTPError(err)
+}
+
+// parseQuery turns the params into a storage query for FindAll and
+// PaginatedFindAll. api2go sets buildingsID for /buildings/:id/floors, those
+// floors are restricted to the building and listed by level unless sort is given.
+func (c FloorResource) parseQuery(r api2go.Request, include includeSet, fields fieldSet) (storage.Query, bool, error) {
+	q, err := parseQuery(r, floorFilters, storage.FloorSortFields)
+	if err != nil {
+		return storage.Query{}, false, err
+	}
+	q.Fields = fields.load("floors", q, include)
+
 	buildingsID, ok := r.QueryParams["buildingsID"]
-	if ok {
-		buildingID := buildingsID[0]
-		building, err := c.BuildingStorage.GetOne(buildingID)
+	if !ok {
+		return q, false, nil
+	}
+	building, err := c.BuildingStorage.GetOne(buildingsID[0])
+	if err != nil {
+		return storage.Query{}, false, toHTTPError(err)
+	}
+	q.Filters = append(q.Filters, storage.Filter{Field: "building", Op: storage.FilterEqual, Value: building.ID})
+	return q, q.Sort == nil, nil
+}
+
+// include loads the relationships asked for with the include param into floors
+func (c FloorResource) include(include includeSet, floors []model.Floor) error {
+	if !include["rooms"] {
+		return nil
+	}
+	return includeRooms(c.RoomStorage, floors)
+}
+
+// PaginatedFindAll can be used to load floors in chunks
+func (c FloorResource) PaginatedFindAll(r api2go.Request) (uint, api2go.Responder, error) {
+	include, err := parseInclude(r, "floors")
+	if err != nil {
+		return 0, &Response{}, err
+	}
+	fields, err := parseFields(r)
+	if err != nil {
+		return 0, &Response{}, err
+	}
+
+	q, byLevel, err := c.parseQuery(r, include, fields)
+	if err != nil {
+		return 0, &Response{}, err
+	}
+
+	p, ok, err := parsePage(r, c.MaxPageSize)
+	if err != nil {
+		return 0, &Response{}, err
+	}
+	if !ok || byLevel {
+		floors, err := c.FloorStorage.Find(q)
 		if err != nil {
-			return &Response{}, err
+			return 0, &Response{}, toHTTPError(err)
+		}
+		if byLevel {
+			sortByLevel(floors)
 		}
+		if ok {
+			n := len(floors)
+			floors = floors[min(p.offset, n):min(p.offset+p.limit, n)]
+			err = c.include(include, floors)
+			resp := pagedResponse(floors, p, n)
+			resp.Fields = fields
+			return uint(n), resp, toHTTPError(err)
+		}
+		err = c.include(include, floors)
+		return uint(len(floors)), &Response{Res: floors, Fields: fields}, toHTTPError(err)
+	}
 
-		return &Response{Res: c.FloorStorage.GetMany(building.FloorsIDs)}, nil
+	n, data, err := c.FloorStorage.PaginatedFind(q, p.limit, p.offset)
+	if err != nil {
+		return 0, &Response{}, toHTTPError(err)
 	}
-	return &Response{Res: c.FloorStorage.GetAll()}, nil
+	err = c.include(include, data)
+	resp := pagedResponse(data, p, n)
+	resp.Fields = fields
+	return uint(n), resp, toHTTPError(err)
 }
 
 // FindOne floor
 func (c FloorResource) FindOne(ID string, r api2go.Request) (api2go.Responder, error) {
+	include, err := parseInclude(r, "floors")
+	if err != nil {
+		return &Response{}, err
+	}
+	fields, err := parseFields(r)
+	if err != nil {
+		return &Response{}, err
+	}
+
 	res, err := c.FloorStorage.GetOne(ID)
-	return &Response{Res: res}, err
+	if err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+
+	floors := []model.Floor{res}
+	err = c.include(include, floors)
+	return &Response{Res: floors[0], Fields: fields}, toHTTPError(err)
 }
 
 // Create a new floor
 func (c FloorResource) Create(obj interface{}, r api2go.Request) (api2go.Responder, error) {
 	floor, ok := obj.(model.Floor)
 	if !ok {
-		return &Response{}, api2go.NewHTTPError(errors.New("Invalid instance given"), "Invalid instance given", http.StatusBadRequest)
+		return &Response{}, badRequest("Invalid instance given")
+	}
+
+	if err := validate(floor); err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+	if err := c.checkBuilding(floor); err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+	if err := c.checkLevel(floor); err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+	if err := c.checkRooms(floor); err != nil {
+		return &Response{}, toHTTPError(err)
 	}
 
-	id := c.FloorStorage.Insert(floor)
+	id, err := c.FloorStorage.Insert(floor)
+	if err != nil {
+		return &Response{}, toHTTPError(err)
+	}
 	floor.ID = id
+
+	if floor.BuildingID != "" {
+		if err := addFloorToBuilding(c.BuildingStorage, floor.BuildingID, floor.ID); err != nil {
+			return &Response{}, toHTTPError(err)
+		}
+	}
+
 	return &Response{Res: floor, Code: http.StatusCreated}, nil
 }
 
-// Delete a floor
+// Delete a floor, buildings referencing it are handled according to DeletePolicy
 func (c FloorResource) Delete(id string, r api2go.Request) (api2go.Responder, error) {
-	err := c.FloorStorage.Delete(id)
-	return &Response{Code: http.StatusOK}, err
+	if _, err := c.FloorStorage.GetOne(id); err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+
+	buildings, err := c.referencingBuildings(id)
+	if err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+
+	switch c.DeletePolicy {
+	case FloorDeleteRestrict:
+		if len(buildings) > 0 {
+			err := storage.NewConflictError("Floor with id %s is still referenced by building %s", id, buildings[0].ID)
+			return &Response{}, toHTTPError(err)
+		}
+	case FloorDeleteUnlink:
+		for _, b := range buildings {
+			b.FloorsIDs = withoutID(b.FloorsIDs, id)
+			if err := c.BuildingStorage.Update(b); err != nil {
+				return &Response{}, toHTTPError(err)
+			}
+		}
+	case FloorDeleteCascade:
+		for _, b := range buildings {
+			if err := c.BuildingStorage.Delete(b.ID); err != nil {
+				return &Response{}, toHTTPError(err)
+			}
+			if err := unlinkFloors(c.FloorStorage, b); err != nil {
+				return &Response{}, toHTTPError(err)
+			}
+		}
+	}
+
+	err = c.FloorStorage.Delete(id)
+	return &Response{Code: http.StatusOK}, toHTTPError(err)
+}
+
+func (c FloorResource) referencingBuildings(floorID string) ([]model.Building, error) {
+	all, err := c.BuildingStorage.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []model.Building{}
+	for _, b := range all {
+		for _, id := range b.FloorsIDs {
+			if id == floorID {
+				result = append(result, b)
+				break
+			}
+		}
+	}
+
+	return result, nil
 }
 
 // Update a floor
 func (c FloorResource) Update(obj interface{}, r api2go.Request) (api2go.Responder, error) {
 	floor, ok := obj.(model.Floor)
 	if !ok {
-		return &Response{}, api2go.NewHTTPError(errors.New("Invalid instance given"), "Invalid instance given", http.StatusBadRequest)
+		return &Response{}, badRequest("Invalid instance given")
+	}
+
+	old, err := c.FloorStorage.GetOne(floor.ID)
+	if err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+
+	if err := validate(floor); err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+	if err := c.checkBuilding(floor); err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+	if err := c.checkLevel(floor); err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+	if err := c.checkRooms(floor); err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+
+	if err := c.FloorStorage.Update(floor); err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+
+	if old.BuildingID != floor.BuildingID {
+		if old.BuildingID != "" {
+			if err := removeFloorFromBuilding(c.BuildingStorage, old.BuildingID, floor.ID); err != nil {
+				return &Response{}, toHTTPError(err)
+			}
+		}
+		if floor.BuildingID != "" {
+			if err := addFloorToBuilding(c.BuildingStorage, floor.BuildingID, floor.ID); err != nil {
+				return &Response{}, toHTTPError(err)
+			}
+		}
+	}
+
+	return &Response{Res: floor, Code: http.StatusNoContent}, nil
+}
+
+// checkBuilding returns a 404 error if the referenced building does not exist
+func (c FloorResource) checkBuilding(floor model.Floor) error {
+	if floor.BuildingID == "" {
+		return nil
+	}
+
+	_, err := c.BuildingStorage.GetOne(floor.BuildingID)
+	if err == nil {
+		return nil
 	}
 
-	err := c.FloorStorage.Update(floor)
-	return &Response{Res: floor, Code: http.StatusNoContent}, err
+	if !storage.IsNotFound(err) {
+		return err
+	}
+	return newError(err, http.StatusNotFound, CodeNotFound, "Building not found", err.Error(), "/data/relationships/building")
+}
+
+// checkLevel returns a 409 error if another floor of the building of floor is on the same level
+func (c FloorResource) checkLevel(floor model.Floor) error {
+	if floor.Level == nil || floor.BuildingID == "" {
+		return nil
+	}
+
+	building, err := c.BuildingStorage.GetOne(floor.BuildingID)
+	if err != nil {
+		return err
+	}
+	others, err := c.FloorStorage.GetMany(building.FloorsIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, other := range others {
+		if other.ID != floor.ID && other.Level != nil && *other.Level == *floor.Level {
+			detail := fmt.Sprintf("Floor with id %s of building %s is already on level %d", other.ID, floor.BuildingID, *floor.Level)
+			return newError(errors.New(detail), http.StatusConflict, CodeConflict, "Level already taken", detail, "/data/attributes/level")
+		}
+	}
+	return nil
+}
+
+// checkRooms returns a 404 error listing every referenced room that does not
+// exist, or a 409 error listing the referenced rooms already on another floor
+func (c FloorResource) checkRooms(floor model.Floor) error {
+	rooms, err := c.RoomStorage.GetMany(floor.RoomsIDs)
+	if err != nil {
+		return err
+	}
+
+	exists := map[string]bool{}
+	for _, room := range rooms {
+		exists[room.ID] = true
+	}
+
+	httpErr := api2go.NewHTTPError(nil, "Referenced rooms do not exist", http.StatusNotFound)
+	for _, id := range floor.RoomsIDs {
+		if !exists[id] {
+			detail := fmt.Sprintf("Room with id %s does not exist", id)
+			httpErr.Errors = append(httpErr.Errors, errorObject(http.StatusNotFound, CodeNotFound, "Room not found", detail, "/data/relationships/rooms"))
+		}
+	}
+
+	if len(httpErr.Errors) > 0 {
+		return httpErr
+	}
+
+	// a room is on at most one floor
+	httpErr = api2go.NewHTTPError(nil, "Referenced rooms are on another floor", http.StatusConflict)
+	for _, id := range floor.RoomsIDs {
+		others, err := c.FloorStorage.Find(storage.Query{Filters: []storage.Filter{{Field: "rooms", Op: storage.FilterEqual, Value: id}}})
+		if err != nil {
+			return err
+		}
+		for _, other := range others {
+			if other.ID != floor.ID {
+				detail := fmt.Sprintf("Room with id %s is already on floor %s", id, other.ID)
+				httpErr.Errors = append(httpErr.Errors, errorObject(http.StatusConflict, CodeConflict, "Room is on another floor", detail, "/data/relationships/rooms"))
+			}
+		}
+	}
+
+	if len(httpErr.Errors) > 0 {
+		return httpErr
+	}
+	return nil
 }
@@ -0,0 +1,182 @@
+package resource
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Handler wraps the handler of api2go with what api2go can not do itself:
+// the status of rejected relationship writes, sparse fieldsets naming
+// relationships or empty attributes, the self link of paginated collections
+// and ETags. baseURL is the one api2go was created with.
+func Handler(api http.Handler, baseURL string) http.Handler {
+	return ETags(selfLinks(sparseFields(relationshipWrites(api)), baseURL))
+}
+
+// relationshipWrites buffers the responses to writes of relationships. api2go
+// answers them with 204 No Content before it writes the error of a rejected
+// one, the buffer sends the status of the error instead.
+func relationshipWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet || !strings.Contains(req.URL.Path, "/relationships/") {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		res := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+		next.ServeHTTP(res, req)
+		res.flush(w)
+	})
+}
+
+type fieldsContextKey struct{}
+
+// sparseFields takes the fields[type] params out of the request before api2go
+// sees them, its own filter rejects every relationship and every empty
+// attribute named in them. parseFields reads them from the request context
+// and they are added back to the links of the response.
+func sparseFields(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		fields := url.Values{}
+		for key, values := range query {
+			if strings.HasPrefix(key, "fields[") {
+				fields[key] = values
+				query.Del(key)
+			}
+		}
+		if len(fields) == 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		stripped := *req.URL
+		stripped.RawQuery = query.Encode()
+		req = req.WithContext(context.WithValue(req.Context(), fieldsContextKey{}, fields))
+		req.URL = &stripped
+
+		res := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+		next.ServeHTTP(res, req)
+
+		res.editLinks(func(href string) string {
+			u, err := url.Parse(href)
+			if err != nil {
+				return href
+			}
+			params := u.Query()
+			for key, values := range fields {
+				params[key] = values
+			}
+			u.RawQuery = params.Encode()
+			return u.String()
+		})
+		res.flush(w)
+	})
+}
+
+// selfLinks adds the self link to the pages of collections, api2go only adds
+// first, prev, next and last
+func selfLinks(next http.Handler, baseURL string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet || !isPageRequest(req) {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		res := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+		next.ServeHTTP(res, req)
+
+		if res.status == http.StatusOK {
+			res.setLink("self", strings.TrimRight(baseURL, "/")+req.URL.RequestURI())
+		}
+		res.flush(w)
+	})
+}
+
+// isPageRequest checks for any of the page params
+func isPageRequest(req *http.Request) bool {
+	for key := range req.URL.Query() {
+		if strings.HasPrefix(key, "page[") {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferedResponse keeps the status and body until a wrapper decided on them,
+// headers go to the wrapped writer directly
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *bufferedResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *bufferedResponse) Write(b []byte) (int, error) {
+	return r.body.Write(b)
+}
+
+// WriteHeader keeps the last status written
+func (r *bufferedResponse) WriteHeader(status int) {
+	r.status = status
+}
+
+// flush writes the status and body to w
+func (r *bufferedResponse) flush(w http.ResponseWriter) {
+	w.WriteHeader(r.status)
+	w.Write(r.body.Bytes())
+}
+
+// setLink sets the top level link name of the document in the body
+func (r *bufferedResponse) setLink(name string, href string) {
+	r.changeLinks(func(links map[string]json.RawMessage) {
+		links[name], _ = json.Marshal(href)
+	})
+}
+
+// editLinks replaces the top level links of the document in the body, which
+// are given as a string, by the result of edit
+func (r *bufferedResponse) editLinks(edit func(href string) string) {
+	r.changeLinks(func(links map[string]json.RawMessage) {
+		for name, raw := range links {
+			var href string
+			if err := json.Unmarshal(raw, &href); err == nil {
+				links[name], _ = json.Marshal(edit(href))
+			}
+		}
+	})
+}
+
+// changeLinks calls change with the top level links of the document in the
+// body and writes them back, bodies which are no JSON object are kept
+func (r *bufferedResponse) changeLinks(change func(links map[string]json.RawMessage)) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(r.body.Bytes(), &doc); err != nil || doc == nil {
+		return
+	}
+	links := map[string]json.RawMessage{}
+	if raw, ok := doc["links"]; ok {
+		if err := json.Unmarshal(raw, &links); err != nil {
+			return
+		}
+	}
+
+	change(links)
+	if len(links) == 0 {
+		return
+	}
+	doc["links"], _ = json.Marshal(links)
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return
+	}
+	r.body.Reset()
+	r.body.Write(body)
+}
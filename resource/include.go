@@ -0,0 +1,44 @@
+package resource
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/manyminds/api2go"
+)
+
+// includable maps each resource type to the relationships which can be
+// included with it, and the types those relationships point to
+var includable = map[string]map[string]string{
+	"buildings": {"floors": "floors"},
+	"floors":    {"rooms": "rooms"},
+	"rooms":     {},
+}
+
+// includeSet holds the requested include paths like floors or floors.rooms,
+// a nested path implies all of its prefixes
+type includeSet map[string]bool
+
+// parseInclude checks the comma separated paths of the include param against
+// the relationships of typ, unknown relationships are a 400 error
+func parseInclude(r api2go.Request, typ string) (includeSet, error) {
+	set := includeSet{}
+	// api2go already splits the params at commas
+	for _, path := range r.QueryParams["include"] {
+		if path == "" {
+			continue
+		}
+
+		current := typ
+		names := strings.Split(path, ".")
+		for i, name := range names {
+			next, ok := includable[current][name]
+			if !ok {
+				return nil, badRequest(fmt.Sprintf("Can not include %s, %s has no relationship %s", path, current, name))
+			}
+			set[strings.Join(names[:i+1], ".")] = true
+			current = next
+		}
+	}
+	return set, nil
+}
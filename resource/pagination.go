@@ -0,0 +1,116 @@
+package resource
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/manyminds/api2go"
+)
+
+// DefaultMaxPageSize limits page[size] and page[limit] of resources without a MaxPageSize
+const DefaultMaxPageSize = 100
+
+// page is the window of a collection asked for with page[number] and
+// page[size], or with page[offset] and page[limit]
+type page struct {
+	byNumber bool
+	limit    int
+	offset   int
+}
+
+// parsePage reads the page params of r, ok is false if there are none. Sizes
+// above maxSize, and params which are not numbers, are a 400 error.
+func parsePage(r api2go.Request, maxSize int) (p page, ok bool, err error) {
+	maxSize = maxPageSize(maxSize)
+
+	number, numberExists, err := parsePageParam(r, "page[number]", 1)
+	if err != nil {
+		return page{}, false, err
+	}
+	size, sizeExists, err := parsePageParam(r, "page[size]", 1)
+	if err != nil {
+		return page{}, false, err
+	}
+	if numberExists && sizeExists {
+		if size > maxSize {
+			return page{}, false, pageSizeError("page[size]", maxSize)
+		}
+		return page{byNumber: true, limit: size, offset: size * (number - 1)}, true, nil
+	}
+
+	offset, offsetExists, err := parsePageParam(r, "page[offset]", 0)
+	if err != nil {
+		return page{}, false, err
+	}
+	limit, limitExists, err := parsePageParam(r, "page[limit]", 1)
+	if err != nil {
+		return page{}, false, err
+	}
+	if offsetExists && limitExists {
+		if limit > maxSize {
+			return page{}, false, pageSizeError("page[limit]", maxSize)
+		}
+		return page{limit: limit, offset: offset}, true, nil
+	}
+
+	return page{}, false, nil
+}
+
+// maxPageSize returns DefaultMaxPageSize unless a positive maxSize is configured
+func maxPageSize(maxSize int) int {
+	if maxSize <= 0 {
+		return DefaultMaxPageSize
+	}
+	return maxSize
+}
+
+// parsePageParam parses the page param key, which must be at least min
+func parsePageParam(r api2go.Request, key string, min int) (int, bool, error) {
+	values, ok := r.QueryParams[key]
+	if !ok {
+		return 0, false, nil
+	}
+
+	n, err := strconv.Atoi(values[0])
+	if err != nil || n < min {
+		detail := fmt.Sprintf("%s must be a number of at least %d", key, min)
+		return 0, true, pageParamError(key, detail)
+	}
+	return n, true, nil
+}
+
+// pageSizeError is returned for pages larger than maxSize
+func pageSizeError(key string, maxSize int) error {
+	return pageParamError(key, fmt.Sprintf("%s must not be larger than %d", key, maxSize))
+}
+
+func pageParamError(key string, detail string) error {
+	httpErr := badRequest(detail)
+	httpErr.Errors[0].Source = &api2go.ErrorSource{Parameter: key}
+	return httpErr
+}
+
+// number is the 1-based number of the page, pages partly covering the previous one count as the next page
+func (p page) number() int {
+	return (p.offset+p.limit-1)/p.limit + 1
+}
+
+// pages is the number of pages of size limit in a collection of total resources
+func (p page) pages(total int) int {
+	return (total + p.limit - 1) / p.limit
+}
+
+// meta returns the total number of resources, the number of the page and the number of pages
+func (p page) meta(total int) map[string]interface{} {
+	meta := totalMeta(total)
+	meta["page"] = p.number()
+	meta["pages"] = p.pages(total)
+	return meta
+}
+
+// pagedResponse is the response of a paginated find. The first, last, prev
+// and next links are added by api2go from the total returned alongside, the
+// self link by Handler.
+func pagedResponse(res interface{}, p page, total int) *Response {
+	return &Response{Res: res, Meta: p.meta(total)}
+}
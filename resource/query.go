@@ -0,0 +1,88 @@
+package resource
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/eckyputrady/jsonapicrudexample/storage"
+	"github.com/manyminds/api2go"
+)
+
+// filterOps maps the operator of filter[field][op] to the storage op, no operator means equality
+var filterOps = map[string]storage.FilterOp{
+	"":         storage.FilterEqual,
+	"prefix":   storage.FilterPrefix,
+	"contains": storage.FilterContains,
+}
+
+// buildingFilters lists the filters buildings support together with their operators
+var buildingFilters = map[string][]string{
+	"id":      {""},
+	"address": {"", "prefix", "contains"},
+	"floors":  {""},
+}
+
+// floorFilters lists the filters floors support together with their operators
+var floorFilters = map[string][]string{
+	"id":       {""},
+	"name":     {"", "prefix", "contains"},
+	"building": {""},
+}
+
+var filterKey = regexp.MustCompile(`^filter\[([^\]]+)\](?:\[([^\]]+)\])?$`)
+
+// parseQuery turns the filter[field]=value and filter[field][op]=value params
+// and the sort param into a storage query. Unsupported filters and sort fields
+// not in sorts are a 400 error.
+func parseQuery(r api2go.Request, filters map[string][]string, sorts []string) (storage.Query, error) {
+	q := storage.Query{}
+	if sort, ok := r.QueryParams["sort"]; ok {
+		// api2go already splits the params at commas
+		keys, err := parseSort(strings.Join(sort, ","), sorts)
+		if err != nil {
+			return storage.Query{}, err
+		}
+		q.Sort = keys
+	}
+
+	for key, values := range r.QueryParams {
+		if !strings.HasPrefix(key, "filter[") {
+			continue
+		}
+
+		m := filterKey.FindStringSubmatch(key)
+		if m == nil || !containsString(filters[m[1]], m[2]) {
+			return storage.Query{}, badRequest(fmt.Sprintf("Unsupported filter %s", key))
+		}
+
+		// api2go already splits the params at commas, a value may contain them
+		q.Filters = append(q.Filters, storage.Filter{Field: m[1], Op: filterOps[m[2]], Value: strings.Join(values, ",")})
+	}
+	return q, nil
+}
+
+// parseSort parses sort=address,-id into sort keys, a leading - sorts descending
+func parseSort(param string, sorts []string) ([]storage.SortKey, error) {
+	keys := []storage.SortKey{}
+	for _, field := range strings.Split(param, ",") {
+		key := storage.SortKey{Field: field}
+		if strings.HasPrefix(field, "-") {
+			key = storage.SortKey{Field: field[1:], Desc: true}
+		}
+		if !containsString(sorts, key.Field) {
+			return nil, badRequest(fmt.Sprintf("Unsupported sort field %s", key.Field))
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, other := range list {
+		if other == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package resource
+
+import (
+	"sort"
+
+	"github.com/eckyputrady/jsonapicrudexample/model"
+	"github.com/eckyputrady/jsonapicrudexample/storage"
+)
+
+// Building.FloorsIDs and Floor.BuildingID describe the same relationship from
+// both ends. The helpers in here keep the two ends in sync after one of them
+// was written.
+
+// linkFloors points the floors of building back to it, floors which were in
+// oldFloorsIDs but are not anymore lose their back reference. The floors must
+// not belong to another building, see BuildingResource.checkFloors.
+func linkFloors(floors storage.FloorRepository, oldFloorsIDs []string, building model.Building) error {
+	listed := map[string]bool{}
+	for _, id := range building.FloorsIDs {
+		listed[id] = true
+	}
+
+	// unlink first, a listed floor may take the level of an unlisted one
+	for _, id := range oldFloorsIDs {
+		if listed[id] {
+			continue
+		}
+
+		floor, err := floors.GetOne(id)
+		if err != nil || floor.BuildingID != building.ID {
+			continue
+		}
+		floor.BuildingID = ""
+		if err := floors.Update(floor); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range building.FloorsIDs {
+		floor, err := floors.GetOne(id)
+		if err != nil {
+			return err
+		}
+		if floor.BuildingID == building.ID {
+			continue
+		}
+
+		floor.BuildingID = building.ID
+		if err := floors.Update(floor); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unlinkFloors clears the back reference of the floors of a deleted building
+func unlinkFloors(floors storage.FloorRepository, building model.Building) error {
+	return linkFloors(floors, building.FloorsIDs, model.Building{ID: building.ID})
+}
+
+// removeFloorFromBuilding drops floorID from the floors of the building, if it still exists
+func removeFloorFromBuilding(buildings storage.BuildingRepository, buildingID string, floorID string) error {
+	building, err := buildings.GetOne(buildingID)
+	if err != nil {
+		return nil
+	}
+
+	building.FloorsIDs = withoutID(building.FloorsIDs, floorID)
+	return buildings.Update(building)
+}
+
+// addFloorToBuilding appends floorID to the floors of the building unless it is already there
+func addFloorToBuilding(buildings storage.BuildingRepository, buildingID string, floorID string) error {
+	building, err := buildings.GetOne(buildingID)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range building.FloorsIDs {
+		if id == floorID {
+			return nil
+		}
+	}
+
+	building.FloorsIDs = append(append([]string{}, building.FloorsIDs...), floorID)
+	return buildings.Update(building)
+}
+
+// withoutID returns a copy of ids without id, the storages may share the backing array
+func withoutID(ids []string, id string) []string {
+	result := []string{}
+	for _, other := range ids {
+		if other != id {
+			result = append(result, other)
+		}
+	}
+	return result
+}
+
+// sortByLevel orders floors by level, floors without a level follow in their original order
+func sortByLevel(floors []model.Floor) {
+	sort.SliceStable(floors, func(i, j int) bool {
+		a, b := floors[i].Level, floors[j].Level
+		return a != nil && (b == nil || *a < *b)
+	})
+}
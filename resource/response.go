@@ -1,22 +1,63 @@
 package resource
 
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/manyminds/api2go/jsonapi"
+)
+
 // The Response struct implements api2go.Responder
 type Response struct {
 	Res  interface{}
 	Code int
+	// Meta is returned as the top level meta object
+	Meta map[string]interface{}
+	// PageLinks maps link names like next to the page params of the linked page
+	PageLinks map[string]url.Values
+	// Fields trims Res to the fields asked for with fields[type]
+	Fields fieldSet
 }
 
 // Metadata returns additional meta data
 func (r Response) Metadata() map[string]interface{} {
-	return map[string]interface{}{}
+	if r.Meta == nil {
+		return map[string]interface{}{}
+	}
+	return r.Meta
 }
 
 // Result returns the actual payload
 func (r Response) Result() interface{} {
-	return r.Res
+	return r.Fields.sparse(r.Res)
 }
 
 // StatusCode sets the return status code
 func (r Response) StatusCode() int {
 	return r.Code
 }
+
+// totalMeta tells clients of paginated collections how many resources there are in total
+func totalMeta(total int) map[string]interface{} {
+	return map[string]interface{}{"total": total}
+}
+
+// Links returns the PageLinks as URLs, they keep all params of the request
+// except for the page params, page[size] is kept too
+func (r Response) Links(req *http.Request, requestURL string) jsonapi.Links {
+	links := jsonapi.Links{}
+	for name, params := range r.PageLinks {
+		query := req.URL.Query()
+		for key := range query {
+			if strings.HasPrefix(key, "page[") && key != "page[size]" {
+				delete(query, key)
+			}
+		}
+		for key, values := range params {
+			query[key] = values
+		}
+		links[name] = jsonapi.Link{Href: requestURL + "?" + query.Encode()}
+	}
+	return links
+}
@@ -0,0 +1,148 @@
+package resource
+
+import (
+	"net/http"
+
+	"github.com/eckyputrady/jsonapicrudexample/model"
+	"github.com/eckyputrady/jsonapicrudexample/storage"
+	"github.com/manyminds/api2go"
+)
+
+// RoomResource for api2go routes
+type RoomResource struct {
+	RoomStorage  storage.RoomRepository
+	FloorStorage storage.FloorRepository
+}
+
+// FindAll rooms
+func (c RoomResource) FindAll(r api2go.Request) (api2go.Responder, error) {
+	if _, err := parseInclude(r, "rooms"); err != nil {
+		return &Response{}, err
+	}
+	fields, err := parseFields(r)
+	if err != nil {
+		return &Response{}, err
+	}
+
+	// set by api2go for /floors/:id/rooms
+	floorsID, ok := r.QueryParams["floorsID"]
+	if ok {
+		floor, err := c.FloorStorage.GetOne(floorsID[0])
+		if err != nil {
+			return &Response{}, toHTTPError(err)
+		}
+
+		rooms, err := c.RoomStorage.GetMany(floor.RoomsIDs)
+		return &Response{Res: rooms, Fields: fields}, toHTTPError(err)
+	}
+
+	rooms, err := c.RoomStorage.GetAll()
+	return &Response{Res: rooms, Fields: fields}, toHTTPError(err)
+}
+
+// FindOne room
+func (c RoomResource) FindOne(ID string, r api2go.Request) (api2go.Responder, error) {
+	if _, err := parseInclude(r, "rooms"); err != nil {
+		return &Response{}, err
+	}
+	fields, err := parseFields(r)
+	if err != nil {
+		return &Response{}, err
+	}
+
+	res, err := c.RoomStorage.GetOne(ID)
+	return &Response{Res: res, Fields: fields}, toHTTPError(err)
+}
+
+// Create a new room
+func (c RoomResource) Create(obj interface{}, r api2go.Request) (api2go.Responder, error) {
+	room, ok := obj.(model.Room)
+	if !ok {
+		return &Response{}, badRequest("Invalid instance given")
+	}
+
+	if err := validate(room); err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+
+	id, err := c.RoomStorage.Insert(room)
+	if err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+	room.ID = id
+
+	return &Response{Res: room, Code: http.StatusCreated}, nil
+}
+
+// Delete a room and remove it from the floors listing it
+func (c RoomResource) Delete(id string, r api2go.Request) (api2go.Responder, error) {
+	if _, err := c.RoomStorage.GetOne(id); err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+
+	floors, err := c.FloorStorage.GetAll()
+	if err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+	for _, f := range floors {
+		if !containsString(f.RoomsIDs, id) {
+			continue
+		}
+		f.RoomsIDs = withoutID(f.RoomsIDs, id)
+		if err := c.FloorStorage.Update(f); err != nil {
+			return &Response{}, toHTTPError(err)
+		}
+	}
+
+	err = c.RoomStorage.Delete(id)
+	return &Response{Code: http.StatusNoContent}, toHTTPError(err)
+}
+
+// Update a room
+func (c RoomResource) Update(obj interface{}, r api2go.Request) (api2go.Responder, error) {
+	room, ok := obj.(model.Room)
+	if !ok {
+		return &Response{}, badRequest("Invalid instance given")
+	}
+
+	if _, err := c.RoomStorage.GetOne(room.ID); err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+	if err := validate(room); err != nil {
+		return &Response{}, toHTTPError(err)
+	}
+
+	err := c.RoomStorage.Update(room)
+	return &Response{Res: room, Code: http.StatusNoContent}, toHTTPError(err)
+}
+
+// includeRooms loads the rooms of all floors of the groups with a single
+// storage call, the groups are filled in place
+func includeRooms(rooms storage.RoomRepository, groups ...[]model.Floor) error {
+	ids := []string{}
+	for _, floors := range groups {
+		for _, f := range floors {
+			ids = append(ids, f.RoomsIDs...)
+		}
+	}
+
+	found, err := rooms.GetMany(ids)
+	if err != nil {
+		return err
+	}
+
+	// GetMany keeps the order of the IDs, so the rooms of each floor are a contiguous part of found
+	next := 0
+	for _, floors := range groups {
+		for i := range floors {
+			start := next
+			for _, id := range floors[i].RoomsIDs {
+				if next < len(found) && found[next].ID == id {
+					next++
+				}
+			}
+			floors[i].Rooms = found[start:next:next]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package resource
+
+import (
+	"net/http"
+
+	"github.com/eckyputrady/jsonapicrudexample/model"
+	"github.com/manyminds/api2go"
+)
+
+// validate runs the validation hook of a model and turns a model.ValidationError
+// into a 422 response with one error object per invalid attribute
+func validate(v model.Validator) error {
+	err := v.Validate()
+	if err == nil {
+		return nil
+	}
+	return validationError(err)
+}
+
+func validationError(err error) error {
+	httpErr := api2go.NewHTTPError(err, err.Error(), http.StatusUnprocessableEntity)
+	if validationErr, ok := err.(model.ValidationError); ok {
+		for _, attrErr := range validationErr.Errors {
+			detail := attrErr.Attribute + " " + attrErr.Message
+			httpErr.Errors = append(httpErr.Errors, errorObject(http.StatusUnprocessableEntity, CodeInvalidAttribute, "Invalid attribute", detail, "/data/attributes/"+attrErr.Attribute))
+		}
+	}
+	return httpErr
+}
+
+// checkTimestamps returns a 422 error if the client changed CreatedAt or
+// UpdatedAt of building, stored is the building before the change or the
+// zero building if it is new. The storage maintains both timestamps.
+func checkTimestamps(building model.Building, stored model.Building) error {
+	errs := model.ValidationError{}
+	if !building.CreatedAt.Equal(stored.CreatedAt) {
+		errs.Errors = append(errs.Errors, model.AttributeError{Attribute: "createdAt", Message: "is set by the server"})
+	}
+	if !building.UpdatedAt.Equal(stored.UpdatedAt) {
+		errs.Errors = append(errs.Errors, model.AttributeError{Attribute: "updatedAt", Message: "is set by the server"})
+	}
+
+	if len(errs.Errors) == 0 {
+		return nil
+	}
+	return validationError(errs)
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	buildingsBucket = []byte("buildings")
+	floorsBucket    = []byte("floors")
+	roomsBucket     = []byte("rooms")
+)
+
+// OpenBolt opens (or creates) the key-value file at path and makes sure a
+// bucket exists for every resource type. The returned handle is shared by the
+// bolt storages.
+func OpenBolt(path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{buildingsBucket, floorsBucket, roomsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// boltKey encodes an ID as big endian so the cursor iterates in ID order
+func boltKey(id string) ([]byte, bool) {
+	seq, err := strconv.ParseUint(id, 10, 64)
+	if err != nil || seq == 0 || strconv.FormatUint(seq, 10) != id {
+		return nil, false
+	}
+
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key, true
+}
+
+func boltID(key []byte) string {
+	return strconv.FormatUint(binary.BigEndian.Uint64(key), 10)
+}
+
+// nextBoltKey takes the next value of the bucket sequence, which is never reused
+func nextBoltKey(b *bolt.Bucket) ([]byte, string, error) {
+	seq, err := b.NextSequence()
+	if err != nil {
+		return nil, "", err
+	}
+
+	id := strconv.FormatUint(seq, 10)
+	key, _ := boltKey(id)
+	return key, id, nil
+}
+
+func boltEncode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func boltDecode(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"sort"
+	"time"
+
+	"github.com/eckyputrady/jsonapicrudexample/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltBuildingStorage stores buildings in the buildings bucket of a bolt file.
+// Like the other backends it stores FloorsIDs as given, the resource checks
+// the floors exist.
+type BoltBuildingStorage struct {
+	db *bolt.DB
+}
+
+// NewBoltBuildingStorage uses a database opened with OpenBolt
+func NewBoltBuildingStorage(db *bolt.DB) *BoltBuildingStorage {
+	return &BoltBuildingStorage{db: db}
+}
+
+// GetAll buildings ordered by ID
+func (s *BoltBuildingStorage) GetAll() ([]model.Building, error) {
+	return s.Find(Query{})
+}
+
+// Find returns the buildings matching q in the order of its sort keys
+func (s *BoltBuildingStorage) Find(q Query) ([]model.Building, error) {
+	if err := q.check(BuildingFilterFields, BuildingSortFields, BuildingFields); err != nil {
+		return nil, err
+	}
+
+	result := []model.Building{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(buildingsBucket).ForEach(func(k, v []byte) error {
+			b := model.Building{}
+			if err := boltDecode(v, &b); err != nil {
+				return err
+			}
+			if q.match(buildingValues(b)) {
+				result = append(result, b)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return q.less(buildingValues(result[i]), buildingValues(result[j]))
+	})
+	return result, nil
+}
+
+// PaginatedFindAll returns all buildings with pagination params
+func (s *BoltBuildingStorage) PaginatedFindAll(page int, size int) (int, []model.Building, error) {
+	offset := size * (page - 1)
+	limit := size
+	return s.PaginatedFindAllLimitOffset(limit, offset)
+}
+
+// PaginatedFindAllLimitOffset returns all buildings with paginated params limit & offset
+func (s *BoltBuildingStorage) PaginatedFindAllLimitOffset(limit int, offset int) (int, []model.Building, error) {
+	return s.PaginatedFind(Query{}, limit, offset)
+}
+
+// PaginatedFind returns the window given by limit and offset of the buildings matching q,
+// together with the number of all matching buildings
+func (s *BoltBuildingStorage) PaginatedFind(q Query, limit int, offset int) (int, []model.Building, error) {
+	all, err := s.Find(q)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	from, to := limitOffset(len(all), limit, offset)
+	return len(all), all[from:to], nil
+}
+
+func getBoltBuilding(tx *bolt.Tx, id string) (model.Building, bool, error) {
+	key, ok := boltKey(id)
+	if !ok {
+		return model.Building{}, false, nil
+	}
+
+	v := tx.Bucket(buildingsBucket).Get(key)
+	if v == nil {
+		return model.Building{}, false, nil
+	}
+
+	b := model.Building{}
+	err := boltDecode(v, &b)
+	return b, err == nil, err
+}
+
+// GetOne building
+func (s *BoltBuildingStorage) GetOne(id string) (model.Building, error) {
+	var b model.Building
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var found bool
+		var err error
+		b, found, err = getBoltBuilding(tx, id)
+		if err == nil && !found {
+			err = NewNotFoundError("Building", id)
+		}
+		return err
+	})
+	if err != nil {
+		return model.Building{}, err
+	}
+
+	return b, nil
+}
+
+// GetMany buildings by IDs, unknown IDs are skipped
+func (s *BoltBuildingStorage) GetMany(ids []string) ([]model.Building, error) {
+	result := []model.Building{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for _, id := range ids {
+			b, found, err := getBoltBuilding(tx, id)
+			if err != nil {
+				return err
+			}
+			if found {
+				result = append(result, b)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// putBoltBuilding stores c under key
+func putBoltBuilding(tx *bolt.Tx, key []byte, c model.Building) error {
+	// floors are loaded by the resource, only the references are stored
+	c.Floors = nil
+	v, err := boltEncode(c)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(buildingsBucket).Put(key, v)
+}
+
+// Insert a building, CreatedAt and UpdatedAt are set to Now
+func (s *BoltBuildingStorage) Insert(c model.Building) (string, error) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		key, id, err := nextBoltKey(tx.Bucket(buildingsBucket))
+		if err != nil {
+			return err
+		}
+
+		c.ID, c.Version = id, 1
+		return putBoltBuilding(tx, key, touch(c, time.Time{}))
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return c.ID, nil
+}
+
+// Delete one building
+func (s *BoltBuildingStorage) Delete(id string) error {
+	return s.DeleteVersion(id, 0)
+}
+
+// DeleteVersion deletes one building if it is at version, in the same
+// transaction as the check. Version 0 deletes it at any.
+func (s *BoltBuildingStorage) DeleteVersion(id string, version int) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		old, found, err := getBoltBuilding(tx, id)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return NewNotFoundError("Building", id)
+		}
+		if err := checkVersion(id, version, old.Version); err != nil {
+			return err
+		}
+		key, _ := boltKey(id)
+		return tx.Bucket(buildingsBucket).Delete(key)
+	})
+}
+
+// Update a building, UpdatedAt is set to Now, CreatedAt is kept and the version is incremented
+func (s *BoltBuildingStorage) Update(c model.Building) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		old, found, err := getBoltBuilding(tx, c.ID)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return NewNotFoundError("Building", c.ID)
+		}
+		c, err = nextVersion(c, old.Version)
+		if err != nil {
+			return err
+		}
+		key, _ := boltKey(c.ID)
+		return putBoltBuilding(tx, key, touch(c, old.CreatedAt))
+	})
+}
@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"sort"
+
+	"github.com/eckyputrady/jsonapicrudexample/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltFloorStorage stores floors in the floors bucket of a bolt file
+type BoltFloorStorage struct {
+	db *bolt.DB
+}
+
+// NewBoltFloorStorage uses a database opened with OpenBolt
+func NewBoltFloorStorage(db *bolt.DB) *BoltFloorStorage {
+	return &BoltFloorStorage{db: db}
+}
+
+// GetAll floors ordered by ID
+func (s *BoltFloorStorage) GetAll() ([]model.Floor, error) {
+	return s.Find(Query{})
+}
+
+// Find returns the floors matching q in the order of its sort keys
+func (s *BoltFloorStorage) Find(q Query) ([]model.Floor, error) {
+	if err := q.check(FloorFilterFields, FloorSortFields, FloorFields); err != nil {
+		return nil, err
+	}
+
+	result := []model.Floor{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(floorsBucket).ForEach(func(k, v []byte) error {
+			f := model.Floor{}
+			if err := boltDecode(v, &f); err != nil {
+				return err
+			}
+			if q.match(floorValues(f)) {
+				result = append(result, f)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return q.less(floorValues(result[i]), floorValues(result[j]))
+	})
+	return result, nil
+}
+
+// PaginatedFindAll returns all floors with pagination params
+func (s *BoltFloorStorage) PaginatedFindAll(page int, size int) (int, []model.Floor, error) {
+	offset := size * (page - 1)
+	limit := size
+	return s.PaginatedFindAllLimitOffset(limit, offset)
+}
+
+// PaginatedFindAllLimitOffset returns all floors with paginated params limit & offset
+func (s *BoltFloorStorage) PaginatedFindAllLimitOffset(limit int, offset int) (int, []model.Floor, error) {
+	return s.PaginatedFind(Query{}, limit, offset)
+}
+
+// PaginatedFind returns the window given by limit and offset of the floors matching q,
+// together with the number of all matching floors
+func (s *BoltFloorStorage) PaginatedFind(q Query, limit int, offset int) (int, []model.Floor, error) {
+	all, err := s.Find(q)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	from, to := limitOffset(len(all), limit, offset)
+	return len(all), all[from:to], nil
+}
+
+func getBoltFloor(tx *bolt.Tx, id string) (model.Floor, bool, error) {
+	key, ok := boltKey(id)
+	if !ok {
+		return model.Floor{}, false, nil
+	}
+
+	v := tx.Bucket(floorsBucket).Get(key)
+	if v == nil {
+		return model.Floor{}, false, nil
+	}
+
+	f := model.Floor{}
+	err := boltDecode(v, &f)
+	return f, err == nil, err
+}
+
+// GetOne floor
+func (s *BoltFloorStorage) GetOne(id string) (model.Floor, error) {
+	var f model.Floor
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var found bool
+		var err error
+		f, found, err = getBoltFloor(tx, id)
+		if err == nil && !found {
+			err = NewNotFoundError("Floor", id)
+		}
+		return err
+	})
+	if err != nil {
+		return model.Floor{}, err
+	}
+
+	return f, nil
+}
+
+// GetMany floors by IDs, unknown IDs are skipped
+func (s *BoltFloorStorage) GetMany(ids []string) ([]model.Floor, error) {
+	result := []model.Floor{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for _, id := range ids {
+			f, found, err := getBoltFloor(tx, id)
+			if err != nil {
+				return err
+			}
+			if found {
+				result = append(result, f)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// checkBoltFloorLevel returns a Conflict error if another floor of the
+// building of c is on its level, in the transaction writing c
+func checkBoltFloorLevel(tx *bolt.Tx, c model.Floor) error {
+	return tx.Bucket(floorsBucket).ForEach(func(k, v []byte) error {
+		other := model.Floor{}
+		if err := boltDecode(v, &other); err != nil {
+			return err
+		}
+		if other.ID == c.ID {
+			return nil
+		}
+		return floorLevelConflict(c, other)
+	})
+}
+
+// Insert a fresh one
+func (s *BoltFloorStorage) Insert(c model.Floor) (string, error) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(floorsBucket)
+		key, id, err := nextBoltKey(b)
+		if err != nil {
+			return err
+		}
+
+		c.ID = id
+		if err := checkBoltFloorLevel(tx, c); err != nil {
+			return err
+		}
+		v, err := boltEncode(c)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, v)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return c.ID, nil
+}
+
+// Delete one floor, the resource unlinks it from its buildings first
+func (s *BoltFloorStorage) Delete(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		key, ok := boltKey(id)
+		if !ok || tx.Bucket(floorsBucket).Get(key) == nil {
+			return NewNotFoundError("Floor", id)
+		}
+		return tx.Bucket(floorsBucket).Delete(key)
+	})
+}
+
+// Update an existing floor
+func (s *BoltFloorStorage) Update(c model.Floor) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		key, ok := boltKey(c.ID)
+		if !ok || tx.Bucket(floorsBucket).Get(key) == nil {
+			return NewNotFoundError("Floor", c.ID)
+		}
+		if err := checkBoltFloorLevel(tx, c); err != nil {
+			return err
+		}
+
+		v, err := boltEncode(c)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(floorsBucket).Put(key, v)
+	})
+}
@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"github.com/eckyputrady/jsonapicrudexample/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltRoomStorage stores rooms in the rooms bucket of a bolt file
+type BoltRoomStorage struct {
+	db *bolt.DB
+}
+
+// NewBoltRoomStorage uses a database opened with OpenBolt
+func NewBoltRoomStorage(db *bolt.DB) *BoltRoomStorage {
+	return &BoltRoomStorage{db: db}
+}
+
+// GetAll rooms ordered by ID
+func (s *BoltRoomStorage) GetAll() ([]model.Room, error) {
+	result := []model.Room{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(roomsBucket).ForEach(func(k, v []byte) error {
+			r := model.Room{}
+			if err := boltDecode(v, &r); err != nil {
+				return err
+			}
+			result = append(result, r)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// PaginatedFindAll returns all rooms with pagination params
+func (s *BoltRoomStorage) PaginatedFindAll(page int, size int) (int, []model.Room, error) {
+	offset := size * (page - 1)
+	limit := size
+	return s.PaginatedFindAllLimitOffset(limit, offset)
+}
+
+// PaginatedFindAllLimitOffset returns all rooms with paginated params limit & offset
+func (s *BoltRoomStorage) PaginatedFindAllLimitOffset(limit int, offset int) (int, []model.Room, error) {
+	all, err := s.GetAll()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	from, to := limitOffset(len(all), limit, offset)
+	return len(all), all[from:to], nil
+}
+
+func getBoltRoom(tx *bolt.Tx, id string) (model.Room, bool, error) {
+	key, ok := boltKey(id)
+	if !ok {
+		return model.Room{}, false, nil
+	}
+
+	v := tx.Bucket(roomsBucket).Get(key)
+	if v == nil {
+		return model.Room{}, false, nil
+	}
+
+	r := model.Room{}
+	err := boltDecode(v, &r)
+	return r, err == nil, err
+}
+
+// GetOne room
+func (s *BoltRoomStorage) GetOne(id string) (model.Room, error) {
+	var r model.Room
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var found bool
+		var err error
+		r, found, err = getBoltRoom(tx, id)
+		if err == nil && !found {
+			err = NewNotFoundError("Room", id)
+		}
+		return err
+	})
+	if err != nil {
+		return model.Room{}, err
+	}
+
+	return r, nil
+}
+
+// GetMany rooms by IDs, unknown IDs are skipped
+func (s *BoltRoomStorage) GetMany(ids []string) ([]model.Room, error) {
+	result := []model.Room{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for _, id := range ids {
+			r, found, err := getBoltRoom(tx, id)
+			if err != nil {
+				return err
+			}
+			if found {
+				result = append(result, r)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Insert a fresh one
+func (s *BoltRoomStorage) Insert(c model.Room) (string, error) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(roomsBucket)
+		key, id, err := nextBoltKey(b)
+		if err != nil {
+			return err
+		}
+
+		c.ID = id
+		v, err := boltEncode(c)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, v)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return c.ID, nil
+}
+
+// Delete one room and unlink it from every floor in the same transaction
+func (s *BoltRoomStorage) Delete(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		key, ok := boltKey(id)
+		if !ok || tx.Bucket(roomsBucket).Get(key) == nil {
+			return NewNotFoundError("Room", id)
+		}
+		if err := tx.Bucket(roomsBucket).Delete(key); err != nil {
+			return err
+		}
+
+		floors := tx.Bucket(floorsBucket)
+		changed := map[string][]byte{}
+		err := floors.ForEach(func(k, v []byte) error {
+			f := model.Floor{}
+			if err := boltDecode(v, &f); err != nil {
+				return err
+			}
+
+			roomsIDs := []string{}
+			for _, roomID := range f.RoomsIDs {
+				if roomID != id {
+					roomsIDs = append(roomsIDs, roomID)
+				}
+			}
+			if len(roomsIDs) == len(f.RoomsIDs) {
+				return nil
+			}
+
+			f.RoomsIDs = roomsIDs
+			v, err := boltEncode(f)
+			if err != nil {
+				return err
+			}
+			changed[string(k)] = v
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// bolt does not allow modifying a bucket while iterating over it
+		for k, v := range changed {
+			if err := floors.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Update an existing room
+func (s *BoltRoomStorage) Update(c model.Room) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		key, ok := boltKey(c.ID)
+		if !ok || tx.Bucket(roomsBucket).Get(key) == nil {
+			return NewNotFoundError("Room", c.ID)
+		}
+
+		v, err := boltEncode(c)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(roomsBucket).Put(key, v)
+	})
+}
@@ -0,0 +1,120 @@
+package storage_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/eckyputrady/jsonapicrudexample/model"
+	"github.com/eckyputrady/jsonapicrudexample/storage"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	bolt "go.etcd.io/bbolt"
+)
+
+var _ = Describe("Bolt Test", func() {
+	var (
+		dir       string
+		db        *bolt.DB
+		buildings *storage.BoltBuildingStorage
+		floors    *storage.BoltFloorStorage
+		rooms     *storage.BoltRoomStorage
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "bolt-test")
+		Expect(err).ToNot(HaveOccurred())
+		db, err = storage.OpenBolt(filepath.Join(dir, "test.bolt"))
+		Expect(err).ToNot(HaveOccurred())
+		buildings = storage.NewBoltBuildingStorage(db)
+		floors = storage.NewBoltFloorStorage(db)
+		rooms = storage.NewBoltRoomStorage(db)
+	})
+
+	AfterEach(func() {
+		db.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("Should use monotonic IDs", func() {
+		floors.Insert(model.Floor{Name: "B1"})
+		floors.Insert(model.Floor{Name: "G"})
+		Expect(floors.Delete("2")).To(Succeed())
+		id, err := floors.Insert(model.Floor{Name: "1"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(id).To(Equal("3"))
+
+		data, _ := floors.GetAll()
+		Expect(data).To(Equal([]model.Floor{{ID: "1", Name: "B1"}, {ID: "3", Name: "1"}}))
+	})
+
+	It("Should return not found errors", func() {
+		_, err := buildings.GetOne("1")
+		Expect(err).To(MatchError("Building with id 1 does not exist"))
+		Expect(storage.IsNotFound(err)).To(BeTrue())
+		Expect(floors.Update(model.Floor{ID: "1"})).To(MatchError("Floor with id 1 does not exist"))
+		Expect(buildings.Delete("x")).To(MatchError("Building with id x does not exist"))
+	})
+
+	It("Should set the timestamps on insert and update", func() {
+		created := time.Date(2016, 1, 2, 3, 4, 5, 6, time.UTC)
+		storage.Now = func() time.Time { return created }
+		lat, lng, year := 1.3, 103.8, 1999
+		buildings.Insert(model.Building{Name: "JEM", Address: "Jurong East", Latitude: &lat, Longitude: &lng, YearBuilt: &year, CreatedAt: time.Now()})
+
+		updated := created.Add(time.Hour)
+		storage.Now = func() time.Time { return updated }
+		Expect(buildings.Update(model.Building{ID: "1", Name: "JEM", Address: "Jurong Gateway", Latitude: &lat, Longitude: &lng, YearBuilt: &year})).To(Succeed())
+
+		data, err := buildings.GetOne("1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data).To(Equal(model.Building{ID: "1", Name: "JEM", Address: "Jurong Gateway", Latitude: &lat, Longitude: &lng, YearBuilt: &year, CreatedAt: created, UpdatedAt: updated, Version: 2}))
+	})
+
+	It("Should paginate correctly", func() {
+		for _, address := range []string{"A", "B", "C", "D"} {
+			buildings.Insert(model.Building{Address: address})
+		}
+
+		len, data, err := buildings.PaginatedFindAll(2, 3)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len).To(Equal(4))
+		Expect(data).To(Equal([]model.Building{{ID: "4", Address: "D", Version: 1}}))
+
+		len, data, _ = buildings.PaginatedFindAllLimitOffset(0, 0)
+		Expect(len).To(Equal(4))
+		Expect(data).To(BeEmpty())
+	})
+
+	It("Should filter floors", func() {
+		floors.Insert(model.Floor{Name: "B1", BuildingID: "1"})
+		floors.Insert(model.Floor{Name: "B2"})
+		floors.Insert(model.Floor{Name: "G", BuildingID: "1"})
+
+		len, data, err := floors.PaginatedFind(storage.Query{Filters: []storage.Filter{{Field: "building", Value: "1"}}}, 1, 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len).To(Equal(2))
+		Expect(data).To(Equal([]model.Floor{{ID: "3", Name: "G", BuildingID: "1"}}))
+
+		found, _ := floors.Find(storage.Query{Filters: []storage.Filter{{Field: "name", Op: storage.FilterPrefix, Value: "B"}}})
+		Expect(found).To(HaveLen(2))
+
+		floors.Insert(model.Floor{Name: "1", RoomsIDs: []string{"7"}})
+		found, _ = floors.Find(storage.Query{Filters: []storage.Filter{{Field: "rooms", Value: "7"}}})
+		Expect(found).To(Equal([]model.Floor{{ID: "4", Name: "1", RoomsIDs: []string{"7"}}}))
+	})
+
+	It("Should unlink a deleted room from its floors", func() {
+		rooms.Insert(model.Room{Name: "Lobby"})
+		rooms.Insert(model.Room{Name: "Hall"})
+		floors.Insert(model.Floor{Name: "G", RoomsIDs: []string{"1", "2"}})
+		Expect(rooms.Delete("1")).To(Succeed())
+
+		data, _ := floors.GetOne("1")
+		Expect(data.RoomsIDs).To(Equal([]string{"2"}))
+		_, err := rooms.GetOne("1")
+		Expect(err).To(MatchError("Room with id 1 does not exist"))
+	})
+})
@@ -1,9 +1,7 @@
 package storage_test
 
 import (
-	"fmt"
-	"strconv"
-	"sync"
+	"time"
 
 	"github.com/eckyputrady/jsonapicrudexample/model"
 	"github.com/eckyputrady/jsonapicrudexample/storage"
@@ -20,138 +18,128 @@ var _ = Describe("Building Test", func() {
 		sut = storage.NewBuildingStorage()
 	})
 
-	Describe("Create", func() {
-		It("Should create successfully", func() {
-			sut.Insert(model.Building{})
-			_, err := sut.GetOne("1")
-			Expect(err).To(BeNil())
-		})
-	})
+	It("Should set the timestamps on insert and update", func() {
+		created := time.Date(2016, 1, 2, 3, 4, 5, 6, time.UTC)
+		storage.Now = func() time.Time { return created }
+		lat, lng, year := 1.3, 103.8, 1999
+		sut.Insert(model.Building{Name: "JEM", Address: "Jurong East", Latitude: &lat, Longitude: &lng, YearBuilt: &year, CreatedAt: time.Now()})
 
-	Describe("Update", func() {
-		It("Should update successfully", func() {
-			sut.Insert(model.Building{Address: "UG"})
-			f := model.Building{Address: "G", ID: "1"}
-			err := sut.Update(f)
-			Expect(err).To(BeNil())
-			data, _ := sut.GetOne("1")
-			Expect(data).To(Equal(f))
-		})
+		updated := created.Add(time.Hour)
+		storage.Now = func() time.Time { return updated }
+		Expect(sut.Update(model.Building{ID: "1", Name: "JEM", Address: "Jurong Gateway", Latitude: &lat, Longitude: &lng, YearBuilt: &year})).To(Succeed())
 
-		It("Should return err if ID not found", func() {
-			_, err := sut.GetOne("-1")
-			Expect(err).ToNot(BeNil())
-		})
+		data, err := sut.GetOne("1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data).To(Equal(model.Building{ID: "1", Name: "JEM", Address: "Jurong Gateway", Latitude: &lat, Longitude: &lng, YearBuilt: &year, CreatedAt: created, UpdatedAt: updated, Version: 2}))
 	})
 
-	Describe("Delete", func() {
-		It("Should delete successfully", func() {
-			sut.Insert(model.Building{})
-			delErr := sut.Delete("1")
-			Expect(delErr).To(BeNil())
-			_, err := sut.GetOne("1")
-			Expect(err).ToNot(BeNil())
+	Describe("Find", func() {
+		BeforeEach(func() {
+			sut.Insert(model.Building{Address: "Jurong East", FloorsIDs: []string{"1", "2"}})
+			sut.Insert(model.Building{Address: "Jurong West", FloorsIDs: []string{"3"}})
+			sut.Insert(model.Building{Address: "Bedok"})
 		})
 
-		It("Should return err if ID not found", func() {
-			delErr := sut.Delete("1")
-			Expect(delErr).ToNot(BeNil())
-		})
-	})
+		It("Should filter by equality, prefix and contains", func() {
+			data, err := sut.Find(storage.Query{Filters: []storage.Filter{{Field: "address", Value: "Bedok"}}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal([]model.Building{{ID: "3", Address: "Bedok", Version: 1}}))
 
-	Describe("Get", func() {
-		It("Should throw err if not found", func() {
-			_, err := sut.GetOne("-1")
-			Expect(err).ToNot(BeNil())
-		})
-	})
+			data, _ = sut.Find(storage.Query{Filters: []storage.Filter{{Field: "address", Op: storage.FilterPrefix, Value: "Jurong"}}})
+			Expect(data).To(HaveLen(2))
 
-	Describe("GetAll", func() {
-		It("Should return empty if no item", func() {
-			data := sut.GetAll()
-			Expect(data).To(BeEmpty())
+			data, _ = sut.Find(storage.Query{Filters: []storage.Filter{{Field: "address", Op: storage.FilterContains, Value: "West"}}})
+			Expect(data).To(HaveLen(1))
+			Expect(data[0].ID).To(Equal("2"))
 		})
 
-		It("Should return all items", func() {
-			sut.Insert(model.Building{})
-			sut.Insert(model.Building{})
-			sut.Insert(model.Building{})
-			data := sut.GetAll()
-			Expect(data).To(Equal([]model.Building{
-				model.Building{ID: "1"},
-				model.Building{ID: "2"},
-				model.Building{ID: "3"},
-			}))
+		It("Should filter by floor membership", func() {
+			data, _ := sut.Find(storage.Query{Filters: []storage.Filter{{Field: "floors", Value: "2"}}})
+			Expect(data).To(HaveLen(1))
+			Expect(data[0].ID).To(Equal("1"))
+		})
 
+		It("Should apply all filters", func() {
+			data, _ := sut.Find(storage.Query{Filters: []storage.Filter{
+				{Field: "address", Op: storage.FilterPrefix, Value: "Jurong"},
+				{Field: "floors", Value: "3"},
+			}})
+			Expect(data).To(HaveLen(1))
+			Expect(data[0].ID).To(Equal("2"))
 		})
-	})
 
-	Describe("PaginateFindAll", func() {
-		BeforeEach(func() {
-			sut.Insert(model.Building{Address: "A"})
-			sut.Insert(model.Building{Address: "B"})
-			sut.Insert(model.Building{Address: "C"})
-			sut.Insert(model.Building{Address: "D"})
+		It("Should count only matching buildings when paginating", func() {
+			len, data, _ := sut.PaginatedFind(storage.Query{Filters: []storage.Filter{{Field: "address", Op: storage.FilterPrefix, Value: "Jurong"}}}, 1, 1)
+			Expect(len).To(Equal(2))
+			Expect(data).To(HaveLen(1))
+			Expect(data[0].ID).To(Equal("2"))
 		})
 
-		It("Should show empty if give out of range params", func() {
-			len, data := sut.PaginatedFindAll(0, 0)
-			Expect(len).To(Equal(4))
-			Expect(data).To(BeEmpty())
+		It("Should reject unknown fields", func() {
+			_, err := sut.Find(storage.Query{Filters: []storage.Filter{{Field: "color", Value: "red"}}})
+			Expect(storage.IsInvalid(err)).To(BeTrue())
+		})
 
-			len, data = sut.PaginatedFindAll(10, 10)
-			Expect(len).To(Equal(4))
-			Expect(data).To(BeEmpty())
+		It("Should sort by multiple keys and keep ties stable", func() {
+			sut.Insert(model.Building{Address: "Bedok"})
 
-			len, data = sut.PaginatedFindAllLimitOffset(0, 0)
-			Expect(len).To(Equal(4))
-			Expect(data).To(BeEmpty())
+			data, err := sut.Find(storage.Query{Sort: []storage.SortKey{{Field: "address"}, {Field: "id", Desc: true}}})
+			Expect(err).ToNot(HaveOccurred())
+			ids := []string{}
+			for _, b := range data {
+				ids = append(ids, b.ID)
+			}
+			Expect(ids).To(Equal([]string{"4", "3", "1", "2"}))
 
-			len, data = sut.PaginatedFindAllLimitOffset(10, 10)
-			Expect(len).To(Equal(4))
-			Expect(data).To(BeEmpty())
+			data, _ = sut.Find(storage.Query{Sort: []storage.SortKey{{Field: "address"}}})
+			Expect(data[0].ID).To(Equal("3"))
+			Expect(data[1].ID).To(Equal("4"))
 		})
 
-		It("Should paginate correctly", func() {
-			len, data := sut.PaginatedFindAll(2, 3)
-			Expect(len).To(Equal(4))
-			expected := []model.Building{model.Building{ID: "4", Address: "D"}}
-			Expect(data).To(Equal(expected))
+		It("Should sort before paginating", func() {
+			_, data, _ := sut.PaginatedFind(storage.Query{Sort: []storage.SortKey{{Field: "address", Desc: true}}}, 1, 0)
+			Expect(data[0].Address).To(Equal("Jurong West"))
 		})
-	})
 
-	Describe("Concurrency", func() {
-		var asyncAddAndModify = func(wg *sync.WaitGroup) {
-			defer wg.Done()
-
-			// insert
-			id := sut.Insert(model.Building{})
-
-			// then either update or delete
-			idInt, _ := strconv.ParseInt(id, 10, 64)
-			if idInt > 50 {
-				sut.Delete(id)
-			} else {
-				sut.Update(model.Building{ID: id, Address: "Updated"})
+		It("Should compare IDs as numbers", func() {
+			for i := 0; i < 8; i++ {
+				sut.Insert(model.Building{})
 			}
-		}
+			data, _ := sut.Find(storage.Query{Sort: []storage.SortKey{{Field: "id", Desc: true}}})
+			Expect(data[0].ID).To(Equal("11"))
+		})
 
-		It("Should be thread safe on writes", func() {
-			var wg sync.WaitGroup
-			wg.Add(100)
-			for i := 0; i < 100; i++ {
-				go asyncAddAndModify(&wg)
-			}
-			wg.Wait()
+		It("Should select the buildings after and before a position", func() {
+			sut.Insert(model.Building{Address: "Bedok"})
+			q := storage.Query{Sort: []storage.SortKey{{Field: "address"}}}
+			third, _ := sut.GetOne("1")
+			q.After = storage.BuildingPosition(q, third)
+			Expect(q.After).To(Equal([]string{"Jurong East", "1"}))
+
+			data, err := sut.Find(q)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(HaveLen(1))
+			Expect(data[0].ID).To(Equal("2"))
+
+			q.After, q.Before = nil, q.After
+			_, data, _ = sut.PaginatedFind(q, 1, 1)
+			Expect(data).To(HaveLen(1))
+			Expect(data[0].ID).To(Equal("4"))
+		})
+
+		It("Should reject positions not fitting the sort keys", func() {
+			_, err := sut.Find(storage.Query{After: []string{"Bedok", "1"}})
+			Expect(storage.IsInvalid(err)).To(BeTrue())
+		})
 
-			actual := sut.GetAll()
-			Expect(actual).To(HaveLen(50))
+		It("Should reject unknown sort fields", func() {
+			_, err := sut.Find(storage.Query{Sort: []storage.SortKey{{Field: "floors"}}})
+			Expect(storage.IsInvalid(err)).To(BeTrue())
+		})
 
-			expected := []model.Building{}
-			for i := 1; i <= 50; i++ {
-				expected = append(expected, model.Building{ID: fmt.Sprintf("%d", i), Address: "Updated"})
-			}
-			Expect(actual).To(Equal(expected))
+		It("Should reject unknown selected fields", func() {
+			_, err := sut.Find(storage.Query{Fields: []string{"address", "color"}})
+			Expect(storage.IsInvalid(err)).To(BeTrue())
 		})
 	})
 })
@@ -0,0 +1,202 @@
+package storage_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/eckyputrady/jsonapicrudexample/model"
+	"github.com/eckyputrady/jsonapicrudexample/storage"
+	"github.com/eckyputrady/jsonapicrudexample/storage/storagetest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// tempDir returns a fresh directory and a func removing it
+func tempDir(prefix string) (string, func()) {
+	dir, err := ioutil.TempDir("", prefix)
+	Expect(err).ToNot(HaveOccurred())
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// every backend is held to the same contract by the specs of storagetest
+var _ = Describe("Conformance", func() {
+	Describe("Memory", func() {
+		Describe("Buildings", func() {
+			storagetest.BuildingSpecs(func() (storage.BuildingRepository, func()) {
+				return storage.NewBuildingStorage(), func() {}
+			})
+		})
+
+		Describe("Floors", func() {
+			storagetest.FloorSpecs(func() (storage.FloorRepository, func()) {
+				return storage.NewFloorStorage(), func() {}
+			})
+		})
+
+		storagetest.FloorsSpecs(func() (storage.BuildingRepository, storage.FloorRepository, func()) {
+			return storage.NewBuildingStorage(), storage.NewFloorStorage(), func() {}
+		})
+
+		Describe("Rooms", func() {
+			storagetest.Specs(func() (storagetest.Repository[model.Room], func()) {
+				return storage.NewRoomStorage(), func() {}
+			}, func(id string, name string, version int) model.Room {
+				return model.Room{ID: id, Name: name}
+			})
+		})
+	})
+
+	Describe("Journal", func() {
+		Describe("Buildings", func() {
+			storagetest.BuildingSpecs(func() (storage.BuildingRepository, func()) {
+				dir, remove := tempDir("journal-conformance")
+				s, err := storage.NewBuildingStorageWithJournal(storage.JournalOptions{Dir: dir, SnapshotEvery: 10})
+				Expect(err).ToNot(HaveOccurred())
+				return s, func() {
+					s.Close()
+					remove()
+				}
+			})
+		})
+
+		Describe("Floors", func() {
+			storagetest.FloorSpecs(func() (storage.FloorRepository, func()) {
+				dir, remove := tempDir("journal-conformance")
+				s, err := storage.NewFloorStorageWithJournal(storage.JournalOptions{Dir: dir, SnapshotEvery: 10})
+				Expect(err).ToNot(HaveOccurred())
+				return s, func() {
+					s.Close()
+					remove()
+				}
+			})
+		})
+
+		storagetest.FloorsSpecs(func() (storage.BuildingRepository, storage.FloorRepository, func()) {
+			dir, remove := tempDir("journal-conformance")
+			buildings, err := storage.NewBuildingStorageWithJournal(storage.JournalOptions{Dir: dir, SnapshotEvery: 10})
+			Expect(err).ToNot(HaveOccurred())
+			floors, err := storage.NewFloorStorageWithJournal(storage.JournalOptions{Dir: dir, SnapshotEvery: 10})
+			Expect(err).ToNot(HaveOccurred())
+			return buildings, floors, func() {
+				buildings.Close()
+				floors.Close()
+				remove()
+			}
+		})
+
+		Describe("Rooms", func() {
+			storagetest.Specs(func() (storagetest.Repository[model.Room], func()) {
+				dir, remove := tempDir("journal-conformance")
+				s, err := storage.NewRoomStorageWithJournal(storage.JournalOptions{Dir: dir, SnapshotEvery: 10})
+				Expect(err).ToNot(HaveOccurred())
+				return s, func() {
+					s.Close()
+					remove()
+				}
+			}, func(id string, name string, version int) model.Room {
+				return model.Room{ID: id, Name: name}
+			})
+		})
+	})
+
+	Describe("SQLite", func() {
+		Describe("Buildings", func() {
+			storagetest.BuildingSpecs(func() (storage.BuildingRepository, func()) {
+				dir, remove := tempDir("sqlite-conformance")
+				db, err := storage.OpenSQLite(filepath.Join(dir, "test.db"))
+				Expect(err).ToNot(HaveOccurred())
+				return storage.NewSQLBuildingStorage(db), func() {
+					db.Close()
+					remove()
+				}
+			})
+		})
+
+		Describe("Floors", func() {
+			storagetest.FloorSpecs(func() (storage.FloorRepository, func()) {
+				dir, remove := tempDir("sqlite-conformance")
+				db, err := storage.OpenSQLite(filepath.Join(dir, "test.db"))
+				Expect(err).ToNot(HaveOccurred())
+				return storage.NewSQLFloorStorage(db), func() {
+					db.Close()
+					remove()
+				}
+			})
+		})
+
+		storagetest.FloorsSpecs(func() (storage.BuildingRepository, storage.FloorRepository, func()) {
+			dir, remove := tempDir("sqlite-conformance")
+			db, err := storage.OpenSQLite(filepath.Join(dir, "test.db"))
+			Expect(err).ToNot(HaveOccurred())
+			return storage.NewSQLBuildingStorage(db), storage.NewSQLFloorStorage(db), func() {
+				db.Close()
+				remove()
+			}
+		})
+
+		Describe("Rooms", func() {
+			storagetest.Specs(func() (storagetest.Repository[model.Room], func()) {
+				dir, remove := tempDir("sqlite-conformance")
+				db, err := storage.OpenSQLite(filepath.Join(dir, "test.db"))
+				Expect(err).ToNot(HaveOccurred())
+				return storage.NewSQLRoomStorage(db), func() {
+					db.Close()
+					remove()
+				}
+			}, func(id string, name string, version int) model.Room {
+				return model.Room{ID: id, Name: name}
+			})
+		})
+	})
+
+	Describe("Bolt", func() {
+		Describe("Buildings", func() {
+			storagetest.BuildingSpecs(func() (storage.BuildingRepository, func()) {
+				dir, remove := tempDir("bolt-conformance")
+				db, err := storage.OpenBolt(filepath.Join(dir, "test.bolt"))
+				Expect(err).ToNot(HaveOccurred())
+				return storage.NewBoltBuildingStorage(db), func() {
+					db.Close()
+					remove()
+				}
+			})
+		})
+
+		Describe("Floors", func() {
+			storagetest.FloorSpecs(func() (storage.FloorRepository, func()) {
+				dir, remove := tempDir("bolt-conformance")
+				db, err := storage.OpenBolt(filepath.Join(dir, "test.bolt"))
+				Expect(err).ToNot(HaveOccurred())
+				return storage.NewBoltFloorStorage(db), func() {
+					db.Close()
+					remove()
+				}
+			})
+		})
+
+		storagetest.FloorsSpecs(func() (storage.BuildingRepository, storage.FloorRepository, func()) {
+			dir, remove := tempDir("bolt-conformance")
+			db, err := storage.OpenBolt(filepath.Join(dir, "test.bolt"))
+			Expect(err).ToNot(HaveOccurred())
+			return storage.NewBoltBuildingStorage(db), storage.NewBoltFloorStorage(db), func() {
+				db.Close()
+				remove()
+			}
+		})
+
+		Describe("Rooms", func() {
+			storagetest.Specs(func() (storagetest.Repository[model.Room], func()) {
+				dir, remove := tempDir("bolt-conformance")
+				db, err := storage.OpenBolt(filepath.Join(dir, "test.bolt"))
+				Expect(err).ToNot(HaveOccurred())
+				return storage.NewBoltRoomStorage(db), func() {
+					db.Close()
+					remove()
+				}
+			}, func(id string, name string, version int) model.Room {
+				return model.Room{ID: id, Name: name}
+			})
+		})
+	})
+})
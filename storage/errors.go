@@ -0,0 +1,74 @@
+package storage
+
+import "fmt"
+
+// ErrorKind tells the expected storage failures apart
+type ErrorKind int
+
+const (
+	// NotFound means the requested record does not exist
+	NotFound ErrorKind = iota + 1
+	// Conflict means the write clashes with the stored state
+	Conflict
+	// Invalid means the record can never be stored as given
+	Invalid
+	// Stale means the write is based on an outdated version of the record
+	Stale
+)
+
+// Error is returned for the failures listed in ErrorKind. Any other error
+// returned by a storage is a failure of the backend itself.
+type Error struct {
+	Kind    ErrorKind
+	Message string
+}
+
+func (e Error) Error() string {
+	return e.Message
+}
+
+// NewNotFoundError reports that the record of type name with the given id does not exist
+func NewNotFoundError(name string, id string) error {
+	return Error{Kind: NotFound, Message: fmt.Sprintf("%s with id %s does not exist", name, id)}
+}
+
+// NewConflictError reports a write that clashes with the stored state
+func NewConflictError(format string, args ...interface{}) error {
+	return Error{Kind: Conflict, Message: fmt.Sprintf(format, args...)}
+}
+
+// NewInvalidError reports a record that can never be stored as given
+func NewInvalidError(format string, args ...interface{}) error {
+	return Error{Kind: Invalid, Message: fmt.Sprintf(format, args...)}
+}
+
+// NewStaleError reports a write of version of the record of type name with
+// the given id while current is stored
+func NewStaleError(name string, id string, version int, current int) error {
+	return Error{Kind: Stale, Message: fmt.Sprintf("%s with id %s is at version %d, not %d", name, id, current, version)}
+}
+
+// IsNotFound checks whether err is a NotFound storage error
+func IsNotFound(err error) bool {
+	return isKind(err, NotFound)
+}
+
+// IsConflict checks whether err is a Conflict storage error
+func IsConflict(err error) bool {
+	return isKind(err, Conflict)
+}
+
+// IsStale checks whether err is a Stale storage error
+func IsStale(err error) bool {
+	return isKind(err, Stale)
+}
+
+// IsInvalid checks whether err is an Invalid storage error
+func IsInvalid(err error) bool {
+	return isKind(err, Invalid)
+}
+
+func isKind(err error, kind ErrorKind) bool {
+	storageErr, ok := err.(Error)
+	return ok && storageErr.Kind == kind
+}
@@ -0,0 +1,303 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SyncPolicy decides when journal writes are flushed to disk
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs after every write, nothing acknowledged is ever lost
+	SyncAlways SyncPolicy = iota
+	// SyncInterval fsyncs in the background every JournalOptions.SyncInterval
+	SyncInterval
+	// SyncNever leaves flushing to the operating system
+	SyncNever
+)
+
+// JournalOptions configures the write-ahead log of the in-memory storages
+type JournalOptions struct {
+	// Dir holds the snapshot and log files, it is created if missing
+	Dir string
+	// Sync is the fsync policy of the log
+	Sync SyncPolicy
+	// SyncInterval is used with SyncInterval, defaults to one second
+	SyncInterval time.Duration
+	// SnapshotEvery compacts the log after this many writes, 0 means 1000
+	SnapshotEvery int
+}
+
+const (
+	journalOpInsert byte = iota + 1
+	journalOpUpdate
+	journalOpDelete
+)
+
+// maxFrameSize bounds the payload of one frame. A larger length in a header
+// can only come from a torn write, so it is not allocated.
+const maxFrameSize = 64 << 20
+
+var errCorruptFrame = errors.New("corrupt journal frame")
+
+// journal is an append-only log of gob encoded frames plus a snapshot file
+// holding the compacted state. Every frame is prefixed with its length and a
+// crc32 checksum so a torn write at the end of the log is detected and dropped.
+type journal struct {
+	opts         JournalOptions
+	logPath      string
+	snapshotPath string
+	file         *os.File
+	writes       int
+
+	mutex sync.Mutex
+	dirty bool
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// openJournal restores the state of name by handing the snapshot to restore
+// and every logged frame to replay, then opens the log for appending
+func openJournal(opts JournalOptions, name string, restore func(dec *gob.Decoder) error, replay func(dec *gob.Decoder) error) (*journal, error) {
+	if opts.SnapshotEvery <= 0 {
+		opts.SnapshotEvery = 1000
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = time.Second
+	}
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	j := &journal{
+		opts:         opts,
+		logPath:      filepath.Join(opts.Dir, name+".log"),
+		snapshotPath: filepath.Join(opts.Dir, name+".snapshot"),
+	}
+
+	snapshot, err := ioutil.ReadFile(j.snapshotPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := restore(gob.NewDecoder(bytes.NewReader(snapshot))); err != nil {
+			return nil, err
+		}
+	}
+
+	j.file, err = os.OpenFile(j.logPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	valid, err := j.replay(replay)
+	if err != nil {
+		j.file.Close()
+		return nil, err
+	}
+
+	// drop a partially written frame left over by a crash
+	if err := j.file.Truncate(valid); err != nil {
+		j.file.Close()
+		return nil, err
+	}
+	if _, err := j.file.Seek(valid, io.SeekStart); err != nil {
+		j.file.Close()
+		return nil, err
+	}
+
+	if opts.Sync == SyncInterval {
+		j.stop = make(chan struct{})
+		j.done = make(chan struct{})
+		go j.syncLoop()
+	}
+
+	return j, nil
+}
+
+// replay feeds all intact frames to fn and returns the offset after the last one
+func (j *journal) replay(fn func(dec *gob.Decoder) error) (int64, error) {
+	r := bufio.NewReader(j.file)
+	var valid int64
+	for {
+		payload, err := readFrame(r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF || err == errCorruptFrame {
+			return valid, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if err := fn(gob.NewDecoder(bytes.NewReader(payload))); err != nil {
+			return 0, err
+		}
+		valid += int64(8 + len(payload))
+		j.writes++
+	}
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[:4])
+	if size > maxFrameSize {
+		return nil, errCorruptFrame
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, errCorruptFrame
+	}
+
+	return payload, nil
+}
+
+// append writes one frame built from values and returns whether the
+// caller should write a snapshot now
+func (j *journal) append(values ...interface{}) (bool, error) {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, 8))
+	enc := gob.NewEncoder(&buf)
+	for _, v := range values {
+		if err := enc.Encode(v); err != nil {
+			return false, err
+		}
+	}
+
+	frame := buf.Bytes()
+	payload := frame[8:]
+	if len(payload) > maxFrameSize {
+		return false, fmt.Errorf("journal frame of %d bytes exceeds %d bytes", len(payload), maxFrameSize)
+	}
+	binary.BigEndian.PutUint32(frame[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload))
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if _, err := j.file.Write(frame); err != nil {
+		return false, err
+	}
+	switch j.opts.Sync {
+	case SyncAlways:
+		if err := j.file.Sync(); err != nil {
+			return false, err
+		}
+	case SyncInterval:
+		j.dirty = true
+	}
+
+	j.writes++
+	return j.writes >= j.opts.SnapshotEvery, nil
+}
+
+// snapshot atomically replaces the snapshot file with values and empties the log
+func (j *journal) snapshot(values ...interface{}) error {
+	tmp, err := ioutil.TempFile(j.opts.Dir, filepath.Base(j.snapshotPath)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	enc := gob.NewEncoder(tmp)
+	for _, v := range values {
+		if err := enc.Encode(v); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), j.snapshotPath); err != nil {
+		return err
+	}
+	// the log is only emptied once the rename itself survives a crash
+	if err := syncDir(j.opts.Dir); err != nil {
+		return err
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if err := j.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := j.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	j.writes = 0
+	j.dirty = false
+	return j.file.Sync()
+}
+
+// syncDir fsyncs the directory dir so renames in it are durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+func (j *journal) syncLoop() {
+	defer close(j.done)
+
+	ticker := time.NewTicker(j.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			j.mutex.Lock()
+			if j.dirty {
+				j.file.Sync()
+				j.dirty = false
+			}
+			j.mutex.Unlock()
+		case <-j.stop:
+			return
+		}
+	}
+}
+
+// Close flushes and closes the log
+func (j *journal) Close() error {
+	if j.stop != nil {
+		close(j.stop)
+		<-j.done
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if err := j.file.Sync(); err != nil {
+		j.file.Close()
+		return err
+	}
+	return j.file.Close()
+}
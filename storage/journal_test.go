@@ -0,0 +1,119 @@
+package storage_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/eckyputrady/jsonapicrudexample/model"
+	"github.com/eckyputrady/jsonapicrudexample/storage"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Journal Test", func() {
+	var (
+		dir  string
+		opts storage.JournalOptions
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "journal-test")
+		Expect(err).ToNot(HaveOccurred())
+		opts = storage.JournalOptions{Dir: dir, Sync: storage.SyncAlways}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	var reopenBuildings = func(sut *storage.BuildingStorage) *storage.BuildingStorage {
+		Expect(sut.Close()).To(Succeed())
+		sut, err := storage.NewBuildingStorageWithJournal(opts)
+		Expect(err).ToNot(HaveOccurred())
+		return sut
+	}
+
+	It("Should replay inserts, updates and deletes", func() {
+		sut, err := storage.NewBuildingStorageWithJournal(opts)
+		Expect(err).ToNot(HaveOccurred())
+		sut.Insert(model.Building{Address: "A", FloorsIDs: []string{"1"}})
+		sut.Insert(model.Building{Address: "B"})
+		sut.Insert(model.Building{Address: "C"})
+		Expect(sut.Update(model.Building{ID: "1", Address: "A2", FloorsIDs: []string{"1", "2"}})).To(Succeed())
+		Expect(sut.Delete("3")).To(Succeed())
+
+		sut = reopenBuildings(sut)
+		defer sut.Close()
+		data, _ := sut.GetAll()
+		Expect(data).To(Equal([]model.Building{
+			{ID: "1", Address: "A2", FloorsIDs: []string{"1", "2"}, Version: 2},
+			{ID: "2", Address: "B", Version: 1},
+		}))
+
+		By("Not reusing the ID of the deleted building")
+		id, _ := sut.Insert(model.Building{})
+		Expect(id).To(Equal("4"))
+	})
+
+	It("Should restore from a snapshot and compact the log", func() {
+		opts.SnapshotEvery = 2
+		sut, err := storage.NewFloorStorageWithJournal(opts)
+		Expect(err).ToNot(HaveOccurred())
+		sut.Insert(model.Floor{Name: "B1"})
+		sut.Insert(model.Floor{Name: "G"})
+		sut.Insert(model.Floor{Name: "1"})
+
+		info, err := os.Stat(filepath.Join(dir, "floors.snapshot"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.Size()).To(BeNumerically(">", 0))
+
+		Expect(sut.Close()).To(Succeed())
+		sut, err = storage.NewFloorStorageWithJournal(opts)
+		Expect(err).ToNot(HaveOccurred())
+		defer sut.Close()
+		data, _ := sut.GetAll()
+		Expect(data).To(Equal([]model.Floor{{ID: "1", Name: "B1"}, {ID: "2", Name: "G"}, {ID: "3", Name: "1"}}))
+	})
+
+	It("Should drop a partially written entry", func() {
+		sut, err := storage.NewBuildingStorageWithJournal(opts)
+		Expect(err).ToNot(HaveOccurred())
+		sut.Insert(model.Building{Address: "A"})
+		Expect(sut.Close()).To(Succeed())
+
+		log, err := os.OpenFile(filepath.Join(dir, "buildings.log"), os.O_APPEND|os.O_WRONLY, 0644)
+		Expect(err).ToNot(HaveOccurred())
+		log.Write([]byte{0, 0, 0, 42, 1, 2})
+		log.Close()
+
+		sut, err = storage.NewBuildingStorageWithJournal(opts)
+		Expect(err).ToNot(HaveOccurred())
+		defer sut.Close()
+		data, _ := sut.GetAll()
+		Expect(data).To(Equal([]model.Building{{ID: "1", Address: "A", Version: 1}}))
+	})
+
+	It("Should drop an entry with an oversized length", func() {
+		sut, err := storage.NewBuildingStorageWithJournal(opts)
+		Expect(err).ToNot(HaveOccurred())
+		sut.Insert(model.Building{Address: "A"})
+		Expect(sut.Close()).To(Succeed())
+
+		path := filepath.Join(dir, "buildings.log")
+		before, _ := os.Stat(path)
+		log, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+		Expect(err).ToNot(HaveOccurred())
+		log.Write([]byte{0xff, 0xff, 0xff, 0xff, 1, 2, 3, 4})
+		log.Close()
+
+		sut, err = storage.NewBuildingStorageWithJournal(opts)
+		Expect(err).ToNot(HaveOccurred())
+		defer sut.Close()
+		data, _ := sut.GetAll()
+		Expect(data).To(Equal([]model.Building{{ID: "1", Address: "A", Version: 1}}))
+		after, _ := os.Stat(path)
+		Expect(after.Size()).To(Equal(before.Size()))
+	})
+})
@@ -0,0 +1,278 @@
+package storage
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/eckyputrady/jsonapicrudexample/model"
+)
+
+// FilterOp is the comparison a Filter applies to a field
+type FilterOp int
+
+const (
+	// FilterEqual matches fields equal to the value
+	FilterEqual FilterOp = iota
+	// FilterPrefix matches fields starting with the value
+	FilterPrefix
+	// FilterContains matches fields containing the value
+	FilterContains
+)
+
+// Filter matches the records whose Field compares to Value. A to-many field
+// like the floors of a building matches if any of its IDs does.
+type Filter struct {
+	Field string
+	Op    FilterOp
+	Value string
+}
+
+// SortKey orders records by Field, descending if Desc is set
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// Query selects the records matching all of its filters, ordered by its sort
+// keys. Records comparing equal on all keys, or all records if there are no
+// keys, are ordered by ID.
+//
+// After and Before are positions as returned by BuildingPosition or
+// FloorPosition for the same sort keys. If set only the records ordered after,
+// respectively before, that position are selected, which allows paginating
+// without skipping or repeating records when others are written meanwhile.
+//
+// Fields lists the attributes and relationships the caller needs, nil means
+// all of them. Backends may leave the other ones empty to save work.
+type Query struct {
+	Filters []Filter
+	Sort    []SortKey
+	After   []string
+	Before  []string
+	Fields  []string
+}
+
+// BuildingFilterFields can be used in the filters of building queries
+var BuildingFilterFields = []string{"id", "address", "floors"}
+
+// FloorFilterFields can be used in the filters of floor queries
+var FloorFilterFields = []string{"id", "name", "building", "rooms"}
+
+// RoomFilterFields can be used in the filters of room queries
+var RoomFilterFields = []string{"id", "name"}
+
+// BuildingSortFields can be used to sort building queries
+var BuildingSortFields = []string{"id", "address"}
+
+// FloorSortFields can be used to sort floor queries
+var FloorSortFields = []string{"id", "name"}
+
+// RoomSortFields can be used to sort room queries
+var RoomSortFields = []string{"id", "name"}
+
+// BuildingFields are the attributes and relationships of buildings which can be selected
+var BuildingFields = []string{"name", "address", "latitude", "longitude", "yearBuilt", "createdAt", "updatedAt", "floors"}
+
+// FloorFields are the attributes and relationships of floors which can be selected
+var FloorFields = []string{"name", "level", "areaSqm", "building", "rooms"}
+
+// RoomFields are the attributes of rooms which can be selected
+var RoomFields = []string{"name"}
+
+// numericFields are compared as numbers when sorting
+var numericFields = map[string]bool{"id": true}
+
+// check returns an Invalid error if a filter, sort key or selected field is not
+// in filterFields, sortFields or fields
+func (q Query) check(filterFields []string, sortFields []string, fields []string) error {
+	for _, f := range q.Filters {
+		if !containsString(filterFields, f.Field) {
+			return NewInvalidError("Unknown filter field %s", f.Field)
+		}
+	}
+	for _, k := range q.Sort {
+		if !containsString(sortFields, k.Field) {
+			return NewInvalidError("Unknown sort field %s", k.Field)
+		}
+	}
+	for _, f := range q.Fields {
+		if !containsString(fields, f) {
+			return NewInvalidError("Unknown field %s", f)
+		}
+	}
+	for _, pos := range [][]string{q.After, q.Before} {
+		if pos != nil && len(pos) != len(q.Sort)+1 {
+			return NewInvalidError("Position does not fit the sort keys")
+		}
+	}
+	return nil
+}
+
+// wants reports whether field is selected by q
+func (q Query) wants(field string) bool {
+	return q.Fields == nil || containsString(q.Fields, field)
+}
+
+// BuildingPosition returns the position of b in the order of q
+func BuildingPosition(q Query, b model.Building) []string {
+	return q.position(buildingValues(b))
+}
+
+// FloorPosition returns the position of f in the order of q
+func FloorPosition(q Query, f model.Floor) []string {
+	return q.position(floorValues(f))
+}
+
+// position returns the values of the sort keys of a record followed by its ID
+func (q Query) position(values func(field string) []string) []string {
+	pos := []string{}
+	for _, k := range q.Sort {
+		pos = append(pos, firstValue(values(k.Field)))
+	}
+	return append(pos, firstValue(values("id")))
+}
+
+// comparePositions orders two positions like the records they belong to
+func (q Query) comparePositions(a []string, b []string) int {
+	for i, k := range q.Sort {
+		c := compareField(k.Field, a[i], b[i])
+		if k.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return compareField("id", a[len(q.Sort)], b[len(q.Sort)])
+}
+
+// match reports whether the record with the given field values matches all
+// filters and lies between After and Before
+func (q Query) match(values func(field string) []string) bool {
+	for _, f := range q.Filters {
+		if !f.matchAny(values(f.Field)) {
+			return false
+		}
+	}
+
+	if q.After == nil && q.Before == nil {
+		return true
+	}
+	pos := q.position(values)
+	if q.After != nil && q.comparePositions(pos, q.After) <= 0 {
+		return false
+	}
+	return q.Before == nil || q.comparePositions(pos, q.Before) < 0
+}
+
+// less reports whether the record with the values a comes before the one with the values b
+func (q Query) less(a func(field string) []string, b func(field string) []string) bool {
+	return q.comparePositions(q.position(a), q.position(b)) < 0
+}
+
+func compareField(field string, a string, b string) int {
+	if numericFields[field] {
+		x, errX := strconv.Atoi(a)
+		y, errY := strconv.Atoi(b)
+		if errX == nil && errY == nil {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+func firstValue(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (f Filter) matchAny(values []string) bool {
+	for _, v := range values {
+		switch f.Op {
+		case FilterEqual:
+			if v == f.Value {
+				return true
+			}
+		case FilterPrefix:
+			if strings.HasPrefix(v, f.Value) {
+				return true
+			}
+		case FilterContains:
+			if strings.Contains(v, f.Value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func buildingValues(b model.Building) func(field string) []string {
+	return func(field string) []string {
+		switch field {
+		case "id":
+			return []string{b.ID}
+		case "address":
+			return []string{b.Address}
+		case "floors":
+			return b.FloorsIDs
+		}
+		return nil
+	}
+}
+
+func floorValues(f model.Floor) func(field string) []string {
+	return func(field string) []string {
+		switch field {
+		case "id":
+			return []string{f.ID}
+		case "name":
+			return []string{f.Name}
+		case "building":
+			return []string{f.BuildingID}
+		case "rooms":
+			return f.RoomsIDs
+		}
+		return nil
+	}
+}
+
+func roomValues(r model.Room) func(field string) []string {
+	return func(field string) []string {
+		switch field {
+		case "id":
+			return []string{r.ID}
+		case "name":
+			return []string{r.Name}
+		}
+		return nil
+	}
+}
+
+// limitOffset returns the indexes of the window given by limit and offset in a slice of length n
+func limitOffset(n int, limit int, offset int) (int, int) {
+	limit, offset = normalizeLimitOffset(limit, offset)
+	if offset > n {
+		offset = n
+	}
+	if limit > n-offset {
+		return offset, n
+	}
+	return offset, offset + limit
+}
+
+func containsString(list []string, s string) bool {
+	for _, other := range list {
+		if other == s {
+			return true
+		}
+	}
+	return false
+}
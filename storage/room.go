@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"github.com/eckyputrady/jsonapicrudexample/model"
+)
+
+// RoomStorage stores all rooms. This is thread-safe.
+type RoomStorage = Store[model.Room]
+
+var roomSchema = Schema[model.Room]{
+	Name:         "Room",
+	Type:         "rooms",
+	FilterFields: RoomFilterFields,
+	SortFields:   RoomSortFields,
+	Fields:       RoomFields,
+	Values:       roomValues,
+}
+
+// NewRoomStorage initializes the storage
+func NewRoomStorage() *RoomStorage {
+	return NewStore(roomSchema)
+}
+
+// NewRoomStorageWithJournal restores the rooms saved in opts.Dir and
+// journals every following write there, see JournalOptions
+func NewRoomStorageWithJournal(opts JournalOptions) (*RoomStorage, error) {
+	return NewStoreWithJournal(roomSchema, opts)
+}
@@ -0,0 +1,276 @@
+package storage
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	// registers the "sqlite3" driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS buildings (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	name       TEXT NOT NULL DEFAULT '',
+	address    TEXT NOT NULL DEFAULT '',
+	latitude   REAL,
+	longitude  REAL,
+	year_built INTEGER,
+	created_at INTEGER NOT NULL DEFAULT 0,
+	updated_at INTEGER NOT NULL DEFAULT 0,
+	version    INTEGER NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS floors (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	name        TEXT NOT NULL DEFAULT '',
+	level       INTEGER,
+	area_sqm    REAL,
+	building_id TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS building_floors (
+	building_id INTEGER NOT NULL REFERENCES buildings(id) ON DELETE CASCADE,
+	position    INTEGER NOT NULL,
+	floor_id    TEXT NOT NULL,
+	PRIMARY KEY (building_id, position)
+);
+
+CREATE TABLE IF NOT EXISTS rooms (
+	id   INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS floor_rooms (
+	floor_id INTEGER NOT NULL REFERENCES floors(id) ON DELETE CASCADE,
+	position INTEGER NOT NULL,
+	room_id  TEXT NOT NULL,
+	PRIMARY KEY (floor_id, position)
+);
+`
+
+// sqlBatchSize bounds the number of IDs bound to one query, SQLite allows at most 999 params
+const sqlBatchSize = 500
+
+// sqliteColumns are added to databases created before the column existed
+var sqliteColumns = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"floors", "building_id", "TEXT NOT NULL DEFAULT ''"},
+	{"buildings", "name", "TEXT NOT NULL DEFAULT ''"},
+	{"buildings", "latitude", "REAL"},
+	{"buildings", "longitude", "REAL"},
+	{"buildings", "year_built", "INTEGER"},
+	{"buildings", "created_at", "INTEGER NOT NULL DEFAULT 0"},
+	{"buildings", "updated_at", "INTEGER NOT NULL DEFAULT 0"},
+	{"floors", "level", "INTEGER"},
+	{"floors", "area_sqm", "REAL"},
+	{"buildings", "version", "INTEGER NOT NULL DEFAULT 1"},
+}
+
+// OpenSQLite opens (or creates) the SQLite database file at path and makes
+// sure the schema exists. The returned handle is shared by the SQL storages.
+func OpenSQLite(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", path+"?_foreign_keys=1")
+	if err != nil {
+		return nil, err
+	}
+
+	// sqlite only supports a single writer, serialize access through one connection
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	for _, c := range sqliteColumns {
+		if err := addSQLiteColumn(db, c.table, c.column, c.definition); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return db, nil
+}
+
+func addSQLiteColumn(db *sql.DB, table string, column string, definition string) error {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return err
+	}
+
+	exists := false
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		exists = exists || name == column
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || exists {
+		return err
+	}
+
+	_, err = db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + definition)
+	return err
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// parseSQLID converts an api ID into a row ID, ok is false if it can never exist
+func parseSQLID(id string) (int64, bool) {
+	rowID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || rowID <= 0 || strconv.FormatInt(rowID, 10) != id {
+		return 0, false
+	}
+	return rowID, true
+}
+
+// requireAffected returns notFound if the statement did not touch any row
+func requireAffected(res sql.Result, notFound error) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound
+	}
+	return nil
+}
+
+// withTx runs fn inside a transaction, committing on success
+func withTx(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// sqlFilter returns the condition comparing column to the value of f. Prefix
+// and contains are case sensitive like the other backends, unlike LIKE.
+func sqlFilter(f Filter, column string) (string, []interface{}) {
+	switch f.Op {
+	case FilterPrefix:
+		return "substr(" + column + ", 1, length(?)) = ?", []interface{}{f.Value, f.Value}
+	case FilterContains:
+		return "instr(" + column + ", ?) > 0", []interface{}{f.Value}
+	}
+	return column + " = ?", []interface{}{f.Value}
+}
+
+// sqlWhere joins the conditions cond builds for the filters of q and the
+// conditions for its After and Before positions into a WHERE clause
+func sqlWhere(q Query, cond func(f Filter) (string, []interface{})) (string, []interface{}) {
+	conds := []string{}
+	args := []interface{}{}
+	for _, f := range q.Filters {
+		c, a := cond(f)
+		conds = append(conds, c)
+		args = append(args, a...)
+	}
+	if q.After != nil {
+		c, a := sqlKeyset(q, q.After, true)
+		conds = append(conds, c)
+		args = append(args, a...)
+	}
+	if q.Before != nil {
+		c, a := sqlKeyset(q, q.Before, false)
+		conds = append(conds, c)
+		args = append(args, a...)
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// sqlKeyset returns the condition selecting the rows ordered after pos, or
+// before it if after is false, in the order given by sqlOrderBy
+func sqlKeyset(q Query, pos []string, after bool) (string, []interface{}) {
+	columns := []string{}
+	desc := []bool{}
+	for _, k := range q.Sort {
+		columns = append(columns, k.Field)
+		desc = append(desc, k.Desc)
+	}
+	columns = append(columns, "id")
+	desc = append(desc, false)
+
+	ors := []string{}
+	args := []interface{}{}
+	for i := range columns {
+		ands := []string{}
+		for j := 0; j < i; j++ {
+			ands = append(ands, columns[j]+" = ?")
+			args = append(args, pos[j])
+		}
+
+		op := ">"
+		if desc[i] == after {
+			op = "<"
+		}
+		ands = append(ands, columns[i]+" "+op+" ?")
+		args = append(args, pos[i])
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	return "(" + strings.Join(ors, " OR ") + ")", args
+}
+
+// sqlOrderBy returns the ORDER BY clause for the sort keys of q, ties are
+// ordered by ID. The sort fields must be checked, they are used as column names.
+func sqlOrderBy(q Query) string {
+	columns := []string{}
+	for _, k := range q.Sort {
+		if k.Desc {
+			columns = append(columns, k.Field+" DESC")
+		} else {
+			columns = append(columns, k.Field)
+		}
+	}
+	return " ORDER BY " + strings.Join(append(columns, "id"), ", ")
+}
+
+// sqlColumn returns column if q selects field, and the literal empty in its
+// place otherwise so rows can be scanned the same way
+func sqlColumn(q Query, field string, column string, empty string) string {
+	if !q.wants(field) {
+		return empty
+	}
+	return column
+}
+
+// sqlTime stores timestamps as unix nanoseconds, the zero time as 0
+func sqlTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// sqlParseTime is the inverse of sqlTime, times are returned in UTC
+func sqlParseTime(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
@@ -0,0 +1,403 @@
+package storage
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eckyputrady/jsonapicrudexample/model"
+)
+
+// SQLBuildingStorage stores buildings in a SQLite database. The floors
+// relationship is kept in the building_floors join table.
+type SQLBuildingStorage struct {
+	db *sql.DB
+}
+
+// NewSQLBuildingStorage uses a database opened with OpenSQLite
+func NewSQLBuildingStorage(db *sql.DB) *SQLBuildingStorage {
+	return &SQLBuildingStorage{db: db}
+}
+
+// scanBuildings reads the rows selected with sqlBuildingColumns, the floors are
+// only looked up if withFloors is set
+func scanBuildings(q queryer, rows *sql.Rows, withFloors bool) ([]model.Building, error) {
+	result := []model.Building{}
+	for rows.Next() {
+		b, err := scanBuilding(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		result = append(result, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if !withFloors {
+		return result, nil
+	}
+
+	return result, loadBuildingFloorsIDs(q, result)
+}
+
+// scanBuilding reads a single row selected with sqlBuildingColumns
+func scanBuilding(row interface {
+	Scan(dest ...interface{}) error
+}) (model.Building, error) {
+	var id, createdAt, updatedAt int64
+	var latitude, longitude sql.NullFloat64
+	var yearBuilt sql.NullInt64
+	b := model.Building{}
+	if err := row.Scan(&id, &b.Name, &b.Address, &latitude, &longitude, &yearBuilt, &createdAt, &updatedAt, &b.Version); err != nil {
+		return model.Building{}, err
+	}
+
+	b.ID = strconv.FormatInt(id, 10)
+	if latitude.Valid {
+		b.Latitude = &latitude.Float64
+	}
+	if longitude.Valid {
+		b.Longitude = &longitude.Float64
+	}
+	if yearBuilt.Valid {
+		year := int(yearBuilt.Int64)
+		b.YearBuilt = &year
+	}
+	b.CreatedAt, b.UpdatedAt = sqlParseTime(createdAt), sqlParseTime(updatedAt)
+	return b, nil
+}
+
+func buildingFloorsIDs(q queryer, id string) ([]string, error) {
+	rows, err := q.Query(`SELECT floor_id FROM building_floors WHERE building_id = ? ORDER BY position`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []string
+	for rows.Next() {
+		var floorID string
+		if err := rows.Scan(&floorID); err != nil {
+			return nil, err
+		}
+		result = append(result, floorID)
+	}
+
+	return result, rows.Err()
+}
+
+// loadBuildingFloorsIDs sets the FloorsIDs of all buildings with one query
+// per sqlBatchSize buildings
+func loadBuildingFloorsIDs(q queryer, buildings []model.Building) error {
+	rowIDs := []interface{}{}
+	seen := map[string]bool{}
+	for _, b := range buildings {
+		if rowID, ok := parseSQLID(b.ID); ok && !seen[b.ID] {
+			seen[b.ID] = true
+			rowIDs = append(rowIDs, rowID)
+		}
+	}
+
+	floorsIDs := map[string][]string{}
+	for start := 0; start < len(rowIDs); start += sqlBatchSize {
+		end := start + sqlBatchSize
+		if end > len(rowIDs) {
+			end = len(rowIDs)
+		}
+		batch := rowIDs[start:end]
+
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(batch)), ", ")
+		rows, err := q.Query(`SELECT building_id, floor_id FROM building_floors WHERE building_id IN (`+placeholders+`) ORDER BY building_id, position`, batch...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var buildingID int64
+			var floorID string
+			if err := rows.Scan(&buildingID, &floorID); err != nil {
+				rows.Close()
+				return err
+			}
+			id := strconv.FormatInt(buildingID, 10)
+			floorsIDs[id] = append(floorsIDs[id], floorID)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	for i := range buildings {
+		buildings[i].FloorsIDs = floorsIDs[buildings[i].ID]
+	}
+	return nil
+}
+
+func replaceBuildingFloorsIDs(q queryer, id int64, floorsIDs []string) error {
+	if _, err := q.Exec(`DELETE FROM building_floors WHERE building_id = ?`, id); err != nil {
+		return err
+	}
+
+	for pos, floorID := range floorsIDs {
+		_, err := q.Exec(`INSERT INTO building_floors (building_id, position, floor_id) VALUES (?, ?, ?)`, id, pos, floorID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetAll buildings ordered by ID
+func (s *SQLBuildingStorage) GetAll() ([]model.Building, error) {
+	return s.Find(Query{})
+}
+
+// Find returns the buildings matching q in the order of its sort keys
+func (s *SQLBuildingStorage) Find(q Query) ([]model.Building, error) {
+	if err := q.check(BuildingFilterFields, BuildingSortFields, BuildingFields); err != nil {
+		return nil, err
+	}
+
+	where, args := sqlWhere(q, sqlBuildingFilter)
+	rows, err := s.db.Query(`SELECT `+sqlBuildingColumns(q)+` FROM buildings`+where+sqlOrderBy(q), args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanBuildings(s.db, rows, q.wants("floors"))
+}
+
+// sqlBuildingColumns selects the id, the attributes and the version, the
+// attributes q leaves out are empty
+func sqlBuildingColumns(q Query) string {
+	return strings.Join([]string{
+		"id",
+		sqlColumn(q, "name", "name", "''"),
+		sqlColumn(q, "address", "address", "''"),
+		sqlColumn(q, "latitude", "latitude", "NULL"),
+		sqlColumn(q, "longitude", "longitude", "NULL"),
+		sqlColumn(q, "yearBuilt", "year_built", "NULL"),
+		sqlColumn(q, "createdAt", "created_at", "0"),
+		sqlColumn(q, "updatedAt", "updated_at", "0"),
+		"version",
+	}, ", ")
+}
+
+func sqlBuildingFilter(f Filter) (string, []interface{}) {
+	switch f.Field {
+	case "id":
+		return sqlFilter(f, "CAST(id AS TEXT)")
+	case "floors":
+		cond, args := sqlFilter(f, "floor_id")
+		return "id IN (SELECT building_id FROM building_floors WHERE " + cond + ")", args
+	}
+	return sqlFilter(f, "address")
+}
+
+// PaginatedFindAll returns all buildings with pagination params
+func (s *SQLBuildingStorage) PaginatedFindAll(page int, size int) (int, []model.Building, error) {
+	offset := size * (page - 1)
+	limit := size
+	return s.PaginatedFindAllLimitOffset(limit, offset)
+}
+
+// PaginatedFindAllLimitOffset returns all building with paginated params limit & offset
+func (s *SQLBuildingStorage) PaginatedFindAllLimitOffset(limit int, offset int) (int, []model.Building, error) {
+	return s.PaginatedFind(Query{}, limit, offset)
+}
+
+// PaginatedFind returns the window given by limit and offset of the buildings matching q,
+// together with the number of all matching buildings
+func (s *SQLBuildingStorage) PaginatedFind(q Query, limit int, offset int) (int, []model.Building, error) {
+	if err := q.check(BuildingFilterFields, BuildingSortFields, BuildingFields); err != nil {
+		return 0, nil, err
+	}
+	limit, offset = normalizeLimitOffset(limit, offset)
+	where, args := sqlWhere(q, sqlBuildingFilter)
+
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM buildings`+where, args...).Scan(&total); err != nil {
+		return 0, nil, err
+	}
+
+	rows, err := s.db.Query(`SELECT `+sqlBuildingColumns(q)+` FROM buildings`+where+sqlOrderBy(q)+` LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		return 0, nil, err
+	}
+	result, err := scanBuildings(s.db, rows, q.wants("floors"))
+	return total, result, err
+}
+
+// GetOne building
+func (s *SQLBuildingStorage) GetOne(id string) (model.Building, error) {
+	b, found, err := findBuilding(s.db, id)
+	if err != nil {
+		return model.Building{}, err
+	}
+	if !found {
+		return model.Building{}, NewNotFoundError("Building", id)
+	}
+
+	return b, nil
+}
+
+func findBuilding(q queryer, id string) (model.Building, bool, error) {
+	rowID, ok := parseSQLID(id)
+	if !ok {
+		return model.Building{}, false, nil
+	}
+
+	b, err := scanBuilding(q.QueryRow(`SELECT `+sqlBuildingColumns(Query{})+` FROM buildings WHERE id = ?`, rowID))
+	if err == sql.ErrNoRows {
+		return model.Building{}, false, nil
+	}
+	if err != nil {
+		return model.Building{}, false, err
+	}
+
+	b.FloorsIDs, err = buildingFloorsIDs(q, id)
+	if err != nil {
+		return model.Building{}, false, err
+	}
+
+	return b, true, nil
+}
+
+// GetMany buildings by IDs, unknown IDs are skipped
+func (s *SQLBuildingStorage) GetMany(ids []string) ([]model.Building, error) {
+	rowIDs := []interface{}{}
+	for _, id := range ids {
+		if rowID, ok := parseSQLID(id); ok {
+			rowIDs = append(rowIDs, rowID)
+		}
+	}
+
+	found := map[string]model.Building{}
+	for start := 0; start < len(rowIDs); start += sqlBatchSize {
+		end := start + sqlBatchSize
+		if end > len(rowIDs) {
+			end = len(rowIDs)
+		}
+		batch := rowIDs[start:end]
+
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(batch)), ", ")
+		rows, err := s.db.Query(`SELECT `+sqlBuildingColumns(Query{})+` FROM buildings WHERE id IN (`+placeholders+`)`, batch...)
+		if err != nil {
+			return nil, err
+		}
+		buildings, err := scanBuildings(s.db, rows, false)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range buildings {
+			found[b.ID] = b
+		}
+	}
+
+	result := []model.Building{}
+	for _, id := range ids {
+		if b, ok := found[id]; ok {
+			result = append(result, b)
+		}
+	}
+	return result, loadBuildingFloorsIDs(s.db, result)
+}
+
+// Insert a building together with its floor references, CreatedAt and UpdatedAt are set to Now
+func (s *SQLBuildingStorage) Insert(c model.Building) (string, error) {
+	var rowID int64
+	err := withTx(s.db, func(tx *sql.Tx) error {
+		c = touch(c, time.Time{})
+		res, err := tx.Exec(`INSERT INTO buildings (name, address, latitude, longitude, year_built, created_at, updated_at, version) VALUES (?, ?, ?, ?, ?, ?, ?, 1)`,
+			c.Name, c.Address, c.Latitude, c.Longitude, c.YearBuilt, sqlTime(c.CreatedAt), sqlTime(c.UpdatedAt))
+		if err != nil {
+			return err
+		}
+
+		rowID, err = res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		return replaceBuildingFloorsIDs(tx, rowID, c.FloorsIDs)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatInt(rowID, 10), nil
+}
+
+// Delete one building
+func (s *SQLBuildingStorage) Delete(id string) error {
+	return s.DeleteVersion(id, 0)
+}
+
+// DeleteVersion deletes one building if it is at version, in the same
+// transaction as the check. Version 0 deletes it at any.
+func (s *SQLBuildingStorage) DeleteVersion(id string, version int) error {
+	rowID, ok := parseSQLID(id)
+	if !ok {
+		return NewNotFoundError("Building", id)
+	}
+
+	return withTx(s.db, func(tx *sql.Tx) error {
+		var stored int
+		err := tx.QueryRow(`SELECT version FROM buildings WHERE id = ?`, rowID).Scan(&stored)
+		if err == sql.ErrNoRows {
+			return NewNotFoundError("Building", id)
+		}
+		if err != nil {
+			return err
+		}
+		if err := checkVersion(id, version, stored); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(`DELETE FROM building_floors WHERE building_id = ?`, rowID); err != nil {
+			return err
+		}
+
+		res, err := tx.Exec(`DELETE FROM buildings WHERE id = ?`, rowID)
+		if err != nil {
+			return err
+		}
+
+		return requireAffected(res, NewNotFoundError("Building", id))
+	})
+}
+
+// Update a building and replace its floor references, UpdatedAt is set to Now
+// and the version is incremented
+func (s *SQLBuildingStorage) Update(c model.Building) error {
+	rowID, ok := parseSQLID(c.ID)
+	if !ok {
+		return NewNotFoundError("Building", c.ID)
+	}
+
+	return withTx(s.db, func(tx *sql.Tx) error {
+		var version int
+		err := tx.QueryRow(`SELECT version FROM buildings WHERE id = ?`, rowID).Scan(&version)
+		if err == sql.ErrNoRows {
+			return NewNotFoundError("Building", c.ID)
+		}
+		if err != nil {
+			return err
+		}
+		if c, err = nextVersion(c, version); err != nil {
+			return err
+		}
+
+		c = touch(c, time.Time{})
+		_, err = tx.Exec(`UPDATE buildings SET name = ?, address = ?, latitude = ?, longitude = ?, year_built = ?, updated_at = ?, version = ? WHERE id = ?`,
+			c.Name, c.Address, c.Latitude, c.Longitude, c.YearBuilt, sqlTime(c.UpdatedAt), c.Version, rowID)
+		if err != nil {
+			return err
+		}
+
+		return replaceBuildingFloorsIDs(tx, rowID, c.FloorsIDs)
+	})
+}
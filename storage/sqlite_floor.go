@@ -0,0 +1,368 @@
+package storage
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/eckyputrady/jsonapicrudexample/model"
+)
+
+// SQLFloorStorage stores floors in a SQLite database. The rooms relationship
+// is kept in the floor_rooms join table.
+type SQLFloorStorage struct {
+	db *sql.DB
+}
+
+// NewSQLFloorStorage uses a database opened with OpenSQLite
+func NewSQLFloorStorage(db *sql.DB) *SQLFloorStorage {
+	return &SQLFloorStorage{db: db}
+}
+
+// scanFloor reads a single row selected with sqlFloorColumns
+func scanFloor(row interface {
+	Scan(dest ...interface{}) error
+}) (model.Floor, error) {
+	var id int64
+	var level sql.NullInt64
+	var areaSqm sql.NullFloat64
+	f := model.Floor{}
+	if err := row.Scan(&id, &f.Name, &level, &areaSqm, &f.BuildingID); err != nil {
+		return model.Floor{}, err
+	}
+
+	f.ID = strconv.FormatInt(id, 10)
+	if level.Valid {
+		l := int(level.Int64)
+		f.Level = &l
+	}
+	if areaSqm.Valid {
+		f.AreaSqm = &areaSqm.Float64
+	}
+	return f, nil
+}
+
+func scanFloors(rows *sql.Rows) ([]model.Floor, error) {
+	defer rows.Close()
+
+	result := []model.Floor{}
+	for rows.Next() {
+		f, err := scanFloor(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, f)
+	}
+
+	return result, rows.Err()
+}
+
+// GetAll floors ordered by ID
+func (s *SQLFloorStorage) GetAll() ([]model.Floor, error) {
+	return s.Find(Query{})
+}
+
+// Find returns the floors matching q in the order of its sort keys
+func (s *SQLFloorStorage) Find(q Query) ([]model.Floor, error) {
+	if err := q.check(FloorFilterFields, FloorSortFields, FloorFields); err != nil {
+		return nil, err
+	}
+
+	where, args := sqlWhere(q, sqlFloorFilter)
+	rows, err := s.db.Query(`SELECT `+sqlFloorColumns(q)+` FROM floors`+where+sqlOrderBy(q), args...)
+	if err != nil {
+		return nil, err
+	}
+	result, err := scanFloors(rows)
+	if err != nil || !q.wants("rooms") {
+		return result, err
+	}
+	return result, loadFloorRoomsIDs(s.db, result)
+}
+
+// sqlFloorColumns selects the id, the attributes and the building, those q leaves out are empty
+func sqlFloorColumns(q Query) string {
+	return strings.Join([]string{
+		"id",
+		sqlColumn(q, "name", "name", "''"),
+		sqlColumn(q, "level", "level", "NULL"),
+		sqlColumn(q, "areaSqm", "area_sqm", "NULL"),
+		sqlColumn(q, "building", "building_id", "''"),
+	}, ", ")
+}
+
+func sqlFloorFilter(f Filter) (string, []interface{}) {
+	switch f.Field {
+	case "id":
+		return sqlFilter(f, "CAST(id AS TEXT)")
+	case "building":
+		return sqlFilter(f, "building_id")
+	case "rooms":
+		cond, args := sqlFilter(f, "room_id")
+		return "id IN (SELECT floor_id FROM floor_rooms WHERE " + cond + ")", args
+	}
+	return sqlFilter(f, "name")
+}
+
+// PaginatedFindAll returns all floors with pagination params
+func (s *SQLFloorStorage) PaginatedFindAll(page int, size int) (int, []model.Floor, error) {
+	offset := size * (page - 1)
+	limit := size
+	return s.PaginatedFindAllLimitOffset(limit, offset)
+}
+
+// PaginatedFindAllLimitOffset returns all floors with paginated params limit & offset
+func (s *SQLFloorStorage) PaginatedFindAllLimitOffset(limit int, offset int) (int, []model.Floor, error) {
+	return s.PaginatedFind(Query{}, limit, offset)
+}
+
+// PaginatedFind returns the window given by limit and offset of the floors matching q,
+// together with the number of all matching floors
+func (s *SQLFloorStorage) PaginatedFind(q Query, limit int, offset int) (int, []model.Floor, error) {
+	if err := q.check(FloorFilterFields, FloorSortFields, FloorFields); err != nil {
+		return 0, nil, err
+	}
+	limit, offset = normalizeLimitOffset(limit, offset)
+	where, args := sqlWhere(q, sqlFloorFilter)
+
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM floors`+where, args...).Scan(&total); err != nil {
+		return 0, nil, err
+	}
+
+	rows, err := s.db.Query(`SELECT `+sqlFloorColumns(q)+` FROM floors`+where+sqlOrderBy(q)+` LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		return 0, nil, err
+	}
+	result, err := scanFloors(rows)
+	if err != nil || !q.wants("rooms") {
+		return total, result, err
+	}
+	return total, result, loadFloorRoomsIDs(s.db, result)
+}
+
+// GetOne floor
+func (s *SQLFloorStorage) GetOne(id string) (model.Floor, error) {
+	f, found, err := findFloor(s.db, id)
+	if err != nil {
+		return model.Floor{}, err
+	}
+	if !found {
+		return model.Floor{}, NewNotFoundError("Floor", id)
+	}
+
+	return f, nil
+}
+
+func findFloor(q queryer, id string) (model.Floor, bool, error) {
+	rowID, ok := parseSQLID(id)
+	if !ok {
+		return model.Floor{}, false, nil
+	}
+
+	f, err := scanFloor(q.QueryRow(`SELECT `+sqlFloorColumns(Query{})+` FROM floors WHERE id = ?`, rowID))
+	if err == sql.ErrNoRows {
+		return model.Floor{}, false, nil
+	}
+	if err != nil {
+		return model.Floor{}, false, err
+	}
+
+	floors := []model.Floor{f}
+	if err := loadFloorRoomsIDs(q, floors); err != nil {
+		return model.Floor{}, false, err
+	}
+
+	return floors[0], true, nil
+}
+
+// loadFloorRoomsIDs fills the RoomsIDs of floors, the join table is read in
+// batches of sqlBatchSize floors
+func loadFloorRoomsIDs(q queryer, floors []model.Floor) error {
+	rowIDs := []interface{}{}
+	seen := map[string]bool{}
+	for _, f := range floors {
+		if rowID, ok := parseSQLID(f.ID); ok && !seen[f.ID] {
+			seen[f.ID] = true
+			rowIDs = append(rowIDs, rowID)
+		}
+	}
+
+	roomsIDs := map[string][]string{}
+	for start := 0; start < len(rowIDs); start += sqlBatchSize {
+		end := start + sqlBatchSize
+		if end > len(rowIDs) {
+			end = len(rowIDs)
+		}
+		batch := rowIDs[start:end]
+
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(batch)), ", ")
+		rows, err := q.Query(`SELECT floor_id, room_id FROM floor_rooms WHERE floor_id IN (`+placeholders+`) ORDER BY floor_id, position`, batch...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var floorID int64
+			var roomID string
+			if err := rows.Scan(&floorID, &roomID); err != nil {
+				rows.Close()
+				return err
+			}
+			id := strconv.FormatInt(floorID, 10)
+			roomsIDs[id] = append(roomsIDs[id], roomID)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	for i := range floors {
+		floors[i].RoomsIDs = roomsIDs[floors[i].ID]
+	}
+	return nil
+}
+
+func replaceFloorRoomsIDs(q queryer, id int64, roomsIDs []string) error {
+	if _, err := q.Exec(`DELETE FROM floor_rooms WHERE floor_id = ?`, id); err != nil {
+		return err
+	}
+
+	for pos, roomID := range roomsIDs {
+		_, err := q.Exec(`INSERT INTO floor_rooms (floor_id, position, room_id) VALUES (?, ?, ?)`, id, pos, roomID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetMany floors by IDs, unknown IDs are skipped. The floors are selected in
+// batches of sqlBatchSize IDs instead of one query per ID.
+func (s *SQLFloorStorage) GetMany(ids []string) ([]model.Floor, error) {
+	rowIDs := []interface{}{}
+	for _, id := range ids {
+		if rowID, ok := parseSQLID(id); ok {
+			rowIDs = append(rowIDs, rowID)
+		}
+	}
+
+	found := map[string]model.Floor{}
+	for start := 0; start < len(rowIDs); start += sqlBatchSize {
+		end := start + sqlBatchSize
+		if end > len(rowIDs) {
+			end = len(rowIDs)
+		}
+		batch := rowIDs[start:end]
+
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(batch)), ", ")
+		rows, err := s.db.Query(`SELECT `+sqlFloorColumns(Query{})+` FROM floors WHERE id IN (`+placeholders+`)`, batch...)
+		if err != nil {
+			return nil, err
+		}
+		floors, err := scanFloors(rows)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range floors {
+			found[f.ID] = f
+		}
+	}
+
+	result := []model.Floor{}
+	for _, id := range ids {
+		if f, ok := found[id]; ok {
+			result = append(result, f)
+		}
+	}
+	return result, loadFloorRoomsIDs(s.db, result)
+}
+
+// checkSQLFloorLevel returns a Conflict error if another floor of the
+// building of c is on its level, in the transaction writing c
+func checkSQLFloorLevel(tx *sql.Tx, c model.Floor) error {
+	if c.BuildingID == "" || c.Level == nil {
+		return nil
+	}
+
+	var otherID int64
+	err := tx.QueryRow(`SELECT id FROM floors WHERE building_id = ? AND level = ? AND CAST(id AS TEXT) != ? LIMIT 1`, c.BuildingID, *c.Level, c.ID).Scan(&otherID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return NewConflictError("Floor with id %d of building %s is already on level %d", otherID, c.BuildingID, *c.Level)
+}
+
+// Insert a floor together with its room references
+func (s *SQLFloorStorage) Insert(c model.Floor) (string, error) {
+	var rowID int64
+	err := withTx(s.db, func(tx *sql.Tx) error {
+		if err := checkSQLFloorLevel(tx, c); err != nil {
+			return err
+		}
+		res, err := tx.Exec(`INSERT INTO floors (name, level, area_sqm, building_id) VALUES (?, ?, ?, ?)`, c.Name, c.Level, c.AreaSqm, c.BuildingID)
+		if err != nil {
+			return err
+		}
+
+		rowID, err = res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		return replaceFloorRoomsIDs(tx, rowID, c.RoomsIDs)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatInt(rowID, 10), nil
+}
+
+// Delete one floor
+func (s *SQLFloorStorage) Delete(id string) error {
+	rowID, ok := parseSQLID(id)
+	if !ok {
+		return NewNotFoundError("Floor", id)
+	}
+
+	return withTx(s.db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM floor_rooms WHERE floor_id = ?`, rowID); err != nil {
+			return err
+		}
+
+		res, err := tx.Exec(`DELETE FROM floors WHERE id = ?`, rowID)
+		if err != nil {
+			return err
+		}
+
+		return requireAffected(res, NewNotFoundError("Floor", id))
+	})
+}
+
+// Update a floor and replace its room references
+func (s *SQLFloorStorage) Update(c model.Floor) error {
+	rowID, ok := parseSQLID(c.ID)
+	if !ok {
+		return NewNotFoundError("Floor", c.ID)
+	}
+
+	return withTx(s.db, func(tx *sql.Tx) error {
+		if err := checkSQLFloorLevel(tx, c); err != nil {
+			return err
+		}
+		res, err := tx.Exec(`UPDATE floors SET name = ?, level = ?, area_sqm = ?, building_id = ? WHERE id = ?`, c.Name, c.Level, c.AreaSqm, c.BuildingID, rowID)
+		if err != nil {
+			return err
+		}
+		if err := requireAffected(res, NewNotFoundError("Floor", c.ID)); err != nil {
+			return err
+		}
+
+		return replaceFloorRoomsIDs(tx, rowID, c.RoomsIDs)
+	})
+}
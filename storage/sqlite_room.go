@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/eckyputrady/jsonapicrudexample/model"
+)
+
+// SQLRoomStorage stores rooms in a SQLite database
+type SQLRoomStorage struct {
+	db *sql.DB
+}
+
+// NewSQLRoomStorage uses a database opened with OpenSQLite
+func NewSQLRoomStorage(db *sql.DB) *SQLRoomStorage {
+	return &SQLRoomStorage{db: db}
+}
+
+func scanRooms(rows *sql.Rows) ([]model.Room, error) {
+	defer rows.Close()
+
+	result := []model.Room{}
+	for rows.Next() {
+		var id int64
+		r := model.Room{}
+		if err := rows.Scan(&id, &r.Name); err != nil {
+			return nil, err
+		}
+		r.ID = strconv.FormatInt(id, 10)
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
+// GetAll rooms ordered by ID
+func (s *SQLRoomStorage) GetAll() ([]model.Room, error) {
+	rows, err := s.db.Query(`SELECT id, name FROM rooms ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	return scanRooms(rows)
+}
+
+// PaginatedFindAll returns all rooms with pagination params
+func (s *SQLRoomStorage) PaginatedFindAll(page int, size int) (int, []model.Room, error) {
+	offset := size * (page - 1)
+	limit := size
+	return s.PaginatedFindAllLimitOffset(limit, offset)
+}
+
+// PaginatedFindAllLimitOffset returns all rooms with paginated params limit & offset
+func (s *SQLRoomStorage) PaginatedFindAllLimitOffset(limit int, offset int) (int, []model.Room, error) {
+	limit, offset = normalizeLimitOffset(limit, offset)
+
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM rooms`).Scan(&total); err != nil {
+		return 0, nil, err
+	}
+
+	rows, err := s.db.Query(`SELECT id, name FROM rooms ORDER BY id LIMIT ? OFFSET ?`, limit, offset)
+	if err != nil {
+		return 0, nil, err
+	}
+	result, err := scanRooms(rows)
+	return total, result, err
+}
+
+// GetOne room
+func (s *SQLRoomStorage) GetOne(id string) (model.Room, error) {
+	rooms, err := s.GetMany([]string{id})
+	if err != nil {
+		return model.Room{}, err
+	}
+	if len(rooms) == 0 {
+		return model.Room{}, NewNotFoundError("Room", id)
+	}
+
+	return rooms[0], nil
+}
+
+// GetMany rooms by IDs, unknown IDs are skipped. The rooms are selected in
+// batches of sqlBatchSize IDs instead of one query per ID.
+func (s *SQLRoomStorage) GetMany(ids []string) ([]model.Room, error) {
+	rowIDs := []interface{}{}
+	for _, id := range ids {
+		if rowID, ok := parseSQLID(id); ok {
+			rowIDs = append(rowIDs, rowID)
+		}
+	}
+
+	found := map[string]model.Room{}
+	for start := 0; start < len(rowIDs); start += sqlBatchSize {
+		end := start + sqlBatchSize
+		if end > len(rowIDs) {
+			end = len(rowIDs)
+		}
+		batch := rowIDs[start:end]
+
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(batch)), ", ")
+		rows, err := s.db.Query(`SELECT id, name FROM rooms WHERE id IN (`+placeholders+`)`, batch...)
+		if err != nil {
+			return nil, err
+		}
+		rooms, err := scanRooms(rows)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rooms {
+			found[r.ID] = r
+		}
+	}
+
+	result := []model.Room{}
+	for _, id := range ids {
+		if r, ok := found[id]; ok {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+// Insert a fresh one
+func (s *SQLRoomStorage) Insert(c model.Room) (string, error) {
+	res, err := s.db.Exec(`INSERT INTO rooms (name) VALUES (?)`, c.Name)
+	if err != nil {
+		return "", err
+	}
+
+	rowID, err := res.LastInsertId()
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatInt(rowID, 10), nil
+}
+
+// Delete one room and unlink it from every floor
+func (s *SQLRoomStorage) Delete(id string) error {
+	rowID, ok := parseSQLID(id)
+	if !ok {
+		return NewNotFoundError("Room", id)
+	}
+
+	return withTx(s.db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM floor_rooms WHERE room_id = ?`, id); err != nil {
+			return err
+		}
+
+		res, err := tx.Exec(`DELETE FROM rooms WHERE id = ?`, rowID)
+		if err != nil {
+			return err
+		}
+
+		return requireAffected(res, NewNotFoundError("Room", id))
+	})
+}
+
+// Update an existing room
+func (s *SQLRoomStorage) Update(c model.Room) error {
+	rowID, ok := parseSQLID(c.ID)
+	if !ok {
+		return NewNotFoundError("Room", c.ID)
+	}
+
+	res, err := s.db.Exec(`UPDATE rooms SET name = ? WHERE id = ?`, c.Name, rowID)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(res, NewNotFoundError("Room", c.ID))
+}
@@ -0,0 +1,277 @@
+package storage_test
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/eckyputrady/jsonapicrudexample/model"
+	"github.com/eckyputrady/jsonapicrudexample/storage"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SQLite Test", func() {
+	var (
+		dir       string
+		db        *sql.DB
+		buildings *storage.SQLBuildingStorage
+		floors    *storage.SQLFloorStorage
+		rooms     *storage.SQLRoomStorage
+	)
+
+	var open = func() {
+		var err error
+		db, err = storage.OpenSQLite(filepath.Join(dir, "test.db"))
+		Expect(err).ToNot(HaveOccurred())
+		buildings = storage.NewSQLBuildingStorage(db)
+		floors = storage.NewSQLFloorStorage(db)
+		rooms = storage.NewSQLRoomStorage(db)
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "sqlite-test")
+		Expect(err).ToNot(HaveOccurred())
+		open()
+	})
+
+	AfterEach(func() {
+		db.Close()
+		os.RemoveAll(dir)
+	})
+
+	Describe("Buildings", func() {
+		It("Should create and update with floors", func() {
+			id, err := buildings.Insert(model.Building{Address: "UG", FloorsIDs: []string{"2", "1"}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(id).To(Equal("1"))
+
+			data, err := buildings.GetOne("1")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal(model.Building{ID: "1", Address: "UG", FloorsIDs: []string{"2", "1"}, Version: 1}))
+
+			f := model.Building{ID: "1", Address: "G", FloorsIDs: []string{"3"}}
+			Expect(buildings.Update(f)).To(Succeed())
+			data, _ = buildings.GetOne("1")
+			f.Version = 2
+			Expect(data).To(Equal(f))
+		})
+
+		It("Should get many with their floors and skip unknown IDs", func() {
+			buildings.Insert(model.Building{Address: "A", FloorsIDs: []string{"2", "1"}})
+			buildings.Insert(model.Building{Address: "B"})
+			data, err := buildings.GetMany([]string{"2", "x", "1", "3", "2"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal([]model.Building{
+				{ID: "2", Address: "B", Version: 1},
+				{ID: "1", Address: "A", FloorsIDs: []string{"2", "1"}, Version: 1},
+				{ID: "2", Address: "B", Version: 1},
+			}))
+		})
+
+		It("Should set the timestamps on insert and update", func() {
+			created := time.Date(2016, 1, 2, 3, 4, 5, 6, time.UTC)
+			storage.Now = func() time.Time { return created }
+			lat, lng, year := 1.3, 103.8, 1999
+			buildings.Insert(model.Building{Name: "JEM", Address: "Jurong East", Latitude: &lat, Longitude: &lng, YearBuilt: &year, CreatedAt: time.Now()})
+
+			updated := created.Add(time.Hour)
+			storage.Now = func() time.Time { return updated }
+			Expect(buildings.Update(model.Building{ID: "1", Name: "JEM", Address: "Jurong Gateway", Latitude: &lat, Longitude: &lng, YearBuilt: &year})).To(Succeed())
+
+			data, err := buildings.GetOne("1")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal(model.Building{ID: "1", Name: "JEM", Address: "Jurong Gateway", Latitude: &lat, Longitude: &lng, YearBuilt: &year, CreatedAt: created, UpdatedAt: updated, Version: 2}))
+		})
+
+		It("Should return the same errors as the map storage", func() {
+			_, err := buildings.GetOne("1")
+			Expect(err).To(MatchError("Building with id 1 does not exist"))
+			Expect(buildings.Update(model.Building{ID: "abc"})).To(MatchError("Building with id abc does not exist"))
+			Expect(buildings.Delete("1")).To(MatchError("Building with id 1 does not exist"))
+		})
+
+		It("Should not reuse IDs after delete", func() {
+			buildings.Insert(model.Building{})
+			buildings.Insert(model.Building{})
+			Expect(buildings.Delete("2")).To(Succeed())
+			id, _ := buildings.Insert(model.Building{})
+			Expect(id).To(Equal("3"))
+
+			data, _ := buildings.GetAll()
+			Expect(data).To(Equal([]model.Building{{ID: "1", Version: 1}, {ID: "3", Version: 1}}))
+		})
+
+		It("Should paginate correctly", func() {
+			buildings.Insert(model.Building{Address: "A"})
+			buildings.Insert(model.Building{Address: "B"})
+			buildings.Insert(model.Building{Address: "C"})
+			buildings.Insert(model.Building{Address: "D"})
+
+			len, data, err := buildings.PaginatedFindAll(2, 3)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len).To(Equal(4))
+			Expect(data).To(Equal([]model.Building{{ID: "4", Address: "D", Version: 1}}))
+
+			len, data, _ = buildings.PaginatedFindAllLimitOffset(10, 10)
+			Expect(len).To(Equal(4))
+			Expect(data).To(BeEmpty())
+		})
+
+		It("Should filter like the map storage", func() {
+			floors.Insert(model.Floor{Name: "G"})
+			buildings.Insert(model.Building{Address: "Jurong East", FloorsIDs: []string{"1"}})
+			buildings.Insert(model.Building{Address: "jurong west"})
+
+			data, err := buildings.Find(storage.Query{Filters: []storage.Filter{{Field: "address", Op: storage.FilterPrefix, Value: "Jurong"}}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal([]model.Building{{ID: "1", Address: "Jurong East", FloorsIDs: []string{"1"}, Version: 1}}))
+
+			len, data, err := buildings.PaginatedFind(storage.Query{Filters: []storage.Filter{{Field: "floors", Value: "1"}}}, 10, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len).To(Equal(1))
+			Expect(data[0].ID).To(Equal("1"))
+
+			data, _ = buildings.Find(storage.Query{Filters: []storage.Filter{{Field: "address", Op: storage.FilterContains, Value: "west"}}})
+			Expect(data).To(HaveLen(1))
+			Expect(data[0].ID).To(Equal("2"))
+		})
+
+		It("Should sort with the ID breaking ties", func() {
+			buildings.Insert(model.Building{Address: "B"})
+			buildings.Insert(model.Building{Address: "A"})
+			buildings.Insert(model.Building{Address: "B"})
+
+			_, data, err := buildings.PaginatedFind(storage.Query{Sort: []storage.SortKey{{Field: "address", Desc: true}}}, 2, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal([]model.Building{{ID: "1", Address: "B", Version: 1}, {ID: "3", Address: "B", Version: 1}}))
+		})
+
+		It("Should select the buildings after a position", func() {
+			buildings.Insert(model.Building{Address: "B"})
+			buildings.Insert(model.Building{Address: "A"})
+			buildings.Insert(model.Building{Address: "B"})
+
+			q := storage.Query{Sort: []storage.SortKey{{Field: "address", Desc: true}}, After: []string{"B", "1"}}
+			data, err := buildings.Find(q)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal([]model.Building{{ID: "3", Address: "B", Version: 1}, {ID: "2", Address: "A", Version: 1}}))
+
+			q.After, q.Before = nil, []string{"A", "2"}
+			n, _, err := buildings.PaginatedFind(q, 0, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(n).To(Equal(2))
+		})
+
+		It("Should only load the selected fields", func() {
+			buildings.Insert(model.Building{Address: "UG", FloorsIDs: []string{"1"}})
+
+			data, err := buildings.Find(storage.Query{Fields: []string{"floors"}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal([]model.Building{{ID: "1", FloorsIDs: []string{"1"}, Version: 1}}))
+
+			_, data, _ = buildings.PaginatedFind(storage.Query{Fields: []string{"address"}}, 10, 0)
+			Expect(data).To(Equal([]model.Building{{ID: "1", Address: "UG", Version: 1}}))
+		})
+	})
+
+	Describe("Floors", func() {
+		It("Should get many and skip unknown IDs", func() {
+			floors.Insert(model.Floor{Name: "B1"})
+			floors.Insert(model.Floor{Name: "G"})
+			data, err := floors.GetMany([]string{"2", "3", "x"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal([]model.Floor{{ID: "2", Name: "G"}}))
+
+			data, _ = floors.GetMany([]string{"2", "1", "2"})
+			Expect(data).To(Equal([]model.Floor{{ID: "2", Name: "G"}, {ID: "1", Name: "B1"}, {ID: "2", Name: "G"}}))
+		})
+
+		It("Should store the level and area", func() {
+			level, area := -2, 800.5
+			floors.Insert(model.Floor{Name: "B2", Level: &level, AreaSqm: &area, BuildingID: "1"})
+			data, err := floors.GetOne("1")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal(model.Floor{ID: "1", Name: "B2", Level: &level, AreaSqm: &area, BuildingID: "1"}))
+
+			data.Level = nil
+			Expect(floors.Update(data)).To(Succeed())
+			data, _ = floors.GetOne("1")
+			Expect(data.Level).To(BeNil())
+		})
+
+		It("Should return err if ID not found", func() {
+			_, err := floors.GetOne("-1")
+			Expect(err).To(MatchError("Floor with id -1 does not exist"))
+			Expect(floors.Delete("1")).To(MatchError("Floor with id 1 does not exist"))
+		})
+
+		It("Should only load the selected fields", func() {
+			floors.Insert(model.Floor{Name: "G", BuildingID: "1"})
+
+			data, err := floors.Find(storage.Query{Fields: []string{"building"}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal([]model.Floor{{ID: "1", BuildingID: "1"}}))
+		})
+	})
+
+	Describe("Rooms", func() {
+		BeforeEach(func() {
+			rooms.Insert(model.Room{Name: "Lobby"})
+			rooms.Insert(model.Room{Name: "Hall"})
+		})
+
+		It("Should store the rooms of a floor in order", func() {
+			floors.Insert(model.Floor{Name: "G", RoomsIDs: []string{"2", "1"}})
+			data, err := floors.GetOne("1")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data.RoomsIDs).To(Equal([]string{"2", "1"}))
+
+			data.RoomsIDs = []string{"1"}
+			Expect(floors.Update(data)).To(Succeed())
+			found, _ := floors.GetMany([]string{"1"})
+			Expect(found[0].RoomsIDs).To(Equal([]string{"1"}))
+
+			all, _ := floors.Find(storage.Query{Fields: []string{"name"}})
+			Expect(all).To(Equal([]model.Floor{{ID: "1", Name: "G"}}))
+		})
+
+		It("Should find the floors of a room", func() {
+			floors.Insert(model.Floor{Name: "G", RoomsIDs: []string{"2"}})
+			floors.Insert(model.Floor{Name: "1", RoomsIDs: []string{"1"}})
+
+			found, err := floors.Find(storage.Query{Filters: []storage.Filter{{Field: "rooms", Value: "1"}}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(found).To(HaveLen(1))
+			Expect(found[0].ID).To(Equal("2"))
+		})
+
+		It("Should unlink a deleted room from its floors", func() {
+			floors.Insert(model.Floor{Name: "G", RoomsIDs: []string{"1", "2"}})
+			Expect(rooms.Delete("1")).To(Succeed())
+
+			data, _ := floors.GetOne("1")
+			Expect(data.RoomsIDs).To(Equal([]string{"2"}))
+			found, _ := rooms.GetAll()
+			Expect(found).To(Equal([]model.Room{{ID: "2", Name: "Hall"}}))
+			Expect(rooms.Delete("1")).To(MatchError("Room with id 1 does not exist"))
+		})
+	})
+
+	It("Should keep data after reopening the database", func() {
+		floors.Insert(model.Floor{Name: "G"})
+		buildings.Insert(model.Building{Address: "Jurong East", FloorsIDs: []string{"1"}})
+		Expect(db.Close()).To(Succeed())
+
+		open()
+		data, err := buildings.GetOne("1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data).To(Equal(model.Building{ID: "1", Address: "Jurong East", FloorsIDs: []string{"1"}, Version: 1}))
+		floor, err := floors.GetOne("1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(floor.Name).To(Equal("G"))
+	})
+})
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/eckyputrady/jsonapicrudexample/model"
+)
+
+// Now returns the time the storages record in CreatedAt and UpdatedAt of
+// buildings. Tests can replace it to get predictable timestamps.
+var Now = func() time.Time {
+	return time.Now().UTC()
+}
+
+// touch sets the timestamps of a building which is about to be stored,
+// created is when the stored building was created or zero for a new one
+func touch(c model.Building, created time.Time) model.Building {
+	now := Now()
+	if created.IsZero() {
+		created = now
+	}
+	c.CreatedAt, c.UpdatedAt = created, now
+	return c
+}
+
+// nextVersion checks that c was read at the stored version and returns it
+// with the version it gets by the write. Version 0 writes unconditionally.
+func nextVersion(c model.Building, stored int) (model.Building, error) {
+	if err := checkVersion(c.ID, c.Version, stored); err != nil {
+		return c, err
+	}
+	c.Version = stored + 1
+	return c, nil
+}
+
+// checkVersion returns a Stale error if the building with id is written at a
+// version other than the stored one. Version 0 writes unconditionally.
+func checkVersion(id string, version int, stored int) error {
+	if version != 0 && version != stored {
+		return NewStaleError("Building", id, version, stored)
+	}
+	return nil
+}
+
+// BuildingRepository is implemented by every building storage backend.
+// The resource layer only depends on this interface. Insert stores version 1
+// and every Update increments it, an Update of a building read at an older
+// version fails with a Stale error. DeleteVersion deletes only the building at
+// version and fails with a Stale error otherwise, version 0 deletes it at any.
+type BuildingRepository interface {
+	GetAll() ([]model.Building, error)
+	Find(q Query) ([]model.Building, error)
+	GetOne(id string) (model.Building, error)
+	GetMany(ids []string) ([]model.Building, error)
+	PaginatedFindAll(page int, size int) (int, []model.Building, error)
+	PaginatedFindAllLimitOffset(limit int, offset int) (int, []model.Building, error)
+	PaginatedFind(q Query, limit int, offset int) (int, []model.Building, error)
+	Insert(c model.Building) (string, error)
+	Update(c model.Building) error
+	Delete(id string) error
+	DeleteVersion(id string, version int) error
+}
+
+// FloorRepository is implemented by every floor storage backend.
+// The resource layer only depends on this interface. GetMany returns the
+// floors in the order of ids, including repeated ones, and skips unknown IDs.
+type FloorRepository interface {
+	GetAll() ([]model.Floor, error)
+	Find(q Query) ([]model.Floor, error)
+	GetOne(id string) (model.Floor, error)
+	GetMany(ids []string) ([]model.Floor, error)
+	PaginatedFindAll(page int, size int) (int, []model.Floor, error)
+	PaginatedFindAllLimitOffset(limit int, offset int) (int, []model.Floor, error)
+	PaginatedFind(q Query, limit int, offset int) (int, []model.Floor, error)
+	Insert(c model.Floor) (string, error)
+	Update(c model.Floor) error
+	Delete(id string) error
+}
+
+// RoomRepository is implemented by every room storage backend. GetMany
+// returns the rooms in the order of ids and skips unknown IDs.
+type RoomRepository interface {
+	GetAll() ([]model.Room, error)
+	GetOne(id string) (model.Room, error)
+	GetMany(ids []string) ([]model.Room, error)
+	Insert(c model.Room) (string, error)
+	Update(c model.Room) error
+	Delete(id string) error
+}
+
+var (
+	_ BuildingRepository = (*BuildingStorage)(nil)
+	_ FloorRepository    = (*FloorStorage)(nil)
+	_ BuildingRepository = (*SQLBuildingStorage)(nil)
+	_ FloorRepository    = (*SQLFloorStorage)(nil)
+	_ BuildingRepository = (*BoltBuildingStorage)(nil)
+	_ FloorRepository    = (*BoltFloorStorage)(nil)
+	_ RoomRepository     = (*RoomStorage)(nil)
+	_ RoomRepository     = (*SQLRoomStorage)(nil)
+	_ RoomRepository     = (*BoltRoomStorage)(nil)
+)
+
+// normalizeLimitOffset clamps negative pagination params to zero
+func normalizeLimitOffset(limit int, offset int) (int, int) {
+	if offset < 0 {
+		offset = 0
+	}
+	if limit < 0 {
+		limit = 0
+	}
+	return limit, offset
+}
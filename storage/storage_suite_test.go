@@ -1,6 +1,9 @@
 package storage_test
 
 import (
+	"time"
+
+	"github.com/eckyputrady/jsonapicrudexample/storage"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
@@ -11,3 +14,17 @@ func TestStorage(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Storage Test Suite")
 }
+
+// now is the clock the specs found, it is restored after each of them
+var now func() time.Time
+
+// most specs compare whole records, the clock is stopped at the zero time so
+// their timestamps stay empty
+var _ = BeforeEach(func() {
+	now = storage.Now
+	storage.Now = func() time.Time { return time.Time{} }
+})
+
+var _ = AfterEach(func() {
+	storage.Now = now
+})
@@ -0,0 +1,384 @@
+// Package storagetest holds the Ginkgo conformance specs every storage
+// backend has to pass. Call BuildingSpecs, FloorSpecs and FloorsSpecs from a
+// Describe in the test suite of the backend:
+//
+//	var _ = Describe("My backend", func() {
+//		storagetest.BuildingSpecs(func() (storage.BuildingRepository, func()) {
+//			s := open()
+//			return s, s.Close
+//		})
+//	})
+package storagetest
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/eckyputrady/jsonapicrudexample/model"
+	"github.com/eckyputrady/jsonapicrudexample/storage"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Repository is the part of the repository interfaces the specs use, every
+// storage.BuildingRepository is a Repository[model.Building] and every
+// storage.FloorRepository a Repository[model.Floor]
+type Repository[T any] interface {
+	GetAll() ([]T, error)
+	GetOne(id string) (T, error)
+	GetMany(ids []string) ([]T, error)
+	PaginatedFindAll(page int, size int) (int, []T, error)
+	PaginatedFindAllLimitOffset(limit int, offset int) (int, []T, error)
+	Insert(c T) (string, error)
+	Update(c T) error
+	Delete(id string) error
+}
+
+// Factory returns an empty repository for a single spec and a func releasing
+// it after the spec
+type Factory[T any] func() (Repository[T], func())
+
+// BuildingSpecs declares the specs for the building storages made by factory
+func BuildingSpecs(factory func() (storage.BuildingRepository, func())) {
+	Specs(func() (Repository[model.Building], func()) {
+		return factory()
+	}, func(id string, name string, version int) model.Building {
+		return model.Building{ID: id, Address: name, Version: version}
+	})
+
+	Describe("Versions", func() {
+		var (
+			sut     storage.BuildingRepository
+			closeFn func()
+		)
+
+		BeforeEach(func() {
+			sut, closeFn = factory()
+			sut.Insert(model.Building{Address: "A"})
+		})
+
+		AfterEach(func() {
+			closeFn()
+		})
+
+		It("Should update if the version is the stored one", func() {
+			Expect(sut.Update(model.Building{ID: "1", Address: "B", Version: 1})).To(Succeed())
+			data, _ := sut.GetOne("1")
+			Expect(data.Version).To(Equal(2))
+		})
+
+		It("Should refuse to update a stale version", func() {
+			sut.Update(model.Building{ID: "1", Address: "B"})
+			err := sut.Update(model.Building{ID: "1", Address: "C", Version: 1})
+			Expect(storage.IsStale(err)).To(BeTrue())
+			data, _ := sut.GetOne("1")
+			Expect(data.Address).To(Equal("B"))
+			Expect(data.Version).To(Equal(2))
+		})
+
+		It("Should delete only at the stored version", func() {
+			sut.Update(model.Building{ID: "1", Address: "B"})
+			err := sut.DeleteVersion("1", 1)
+			Expect(storage.IsStale(err)).To(BeTrue())
+			_, err = sut.GetOne("1")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(sut.DeleteVersion("1", 2)).To(Succeed())
+			Expect(sut.DeleteVersion("1", 2)).To(MatchError("Building with id 1 does not exist"))
+		})
+	})
+}
+
+// FloorSpecs declares the specs for the floor storages made by factory
+func FloorSpecs(factory func() (storage.FloorRepository, func())) {
+	Specs(func() (Repository[model.Floor], func()) {
+		return factory()
+	}, func(id string, name string, version int) model.Floor {
+		return model.Floor{ID: id, Name: name}
+	})
+
+	Describe("Levels", func() {
+		var (
+			sut     storage.FloorRepository
+			closeFn func()
+		)
+
+		BeforeEach(func() {
+			sut, closeFn = factory()
+		})
+
+		AfterEach(func() {
+			closeFn()
+		})
+
+		It("Should reject a second floor on a level of its building", func() {
+			level := 1
+			_, err := sut.Insert(model.Floor{Name: "1", Level: &level, BuildingID: "1"})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = sut.Insert(model.Floor{Name: "Other 1", Level: &level, BuildingID: "1"})
+			Expect(storage.IsConflict(err)).To(BeTrue())
+			_, err = sut.Insert(model.Floor{Name: "1", Level: &level, BuildingID: "2"})
+			Expect(err).ToNot(HaveOccurred())
+
+			id, err := sut.Insert(model.Floor{Name: "Unlinked 1", Level: &level})
+			Expect(err).ToNot(HaveOccurred())
+			err = sut.Update(model.Floor{ID: id, Name: "Unlinked 1", Level: &level, BuildingID: "1"})
+			Expect(storage.IsConflict(err)).To(BeTrue())
+			Expect(sut.Update(model.Floor{ID: "1", Name: "First", Level: &level, BuildingID: "1"})).To(Succeed())
+		})
+	})
+}
+
+// Specs declares the specs for the repositories made by factory, record
+// returns a record with the given ID and name, which is stored in any
+// attribute. Records are compared as a whole, so the storage clock is
+// stopped at the zero time during the specs. Records are inserted and
+// updated with version 0 and expected back with the stored version, record
+// may ignore it if T is not versioned.
+func Specs[T any](factory Factory[T], record func(id string, name string, version int) T) {
+	var (
+		sut     Repository[T]
+		closeFn func()
+		now     func() time.Time
+	)
+
+	BeforeEach(func() {
+		now = storage.Now
+		storage.Now = func() time.Time { return time.Time{} }
+		sut, closeFn = factory()
+	})
+
+	AfterEach(func() {
+		closeFn()
+		storage.Now = now
+	})
+
+	Describe("Create", func() {
+		It("Should create successfully", func() {
+			id, err := sut.Insert(record("", "", 0))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(id).To(Equal("1"))
+			_, err = sut.GetOne("1")
+			Expect(err).To(BeNil())
+		})
+
+		It("Should ignore the ID of the inserted record", func() {
+			id, _ := sut.Insert(record("7", "A", 0))
+			Expect(id).To(Equal("1"))
+			data, _ := sut.GetOne("1")
+			Expect(data).To(Equal(record("1", "A", 1)))
+		})
+	})
+
+	Describe("Update", func() {
+		It("Should update successfully", func() {
+			sut.Insert(record("", "UG", 0))
+			err := sut.Update(record("1", "G", 0))
+			Expect(err).To(BeNil())
+			data, _ := sut.GetOne("1")
+			Expect(data).To(Equal(record("1", "G", 2)))
+		})
+
+		It("Should return err if ID not found", func() {
+			err := sut.Update(record("1", "G", 0))
+			Expect(storage.IsNotFound(err)).To(BeTrue())
+		})
+	})
+
+	Describe("Delete", func() {
+		It("Should delete successfully", func() {
+			sut.Insert(record("", "", 0))
+			delErr := sut.Delete("1")
+			Expect(delErr).To(BeNil())
+			_, err := sut.GetOne("1")
+			Expect(err).ToNot(BeNil())
+		})
+
+		It("Should return err if ID not found", func() {
+			delErr := sut.Delete("1")
+			Expect(storage.IsNotFound(delErr)).To(BeTrue())
+			Expect(storage.IsNotFound(sut.Delete("x"))).To(BeTrue())
+		})
+
+		It("Should not reuse the ID of a deleted record", func() {
+			sut.Insert(record("", "", 0))
+			sut.Delete("1")
+			id, _ := sut.Insert(record("", "", 0))
+			Expect(id).To(Equal("2"))
+		})
+	})
+
+	Describe("Get", func() {
+		It("Should throw err if not found", func() {
+			for _, id := range []string{"1", "-1", "0", "01", "x", ""} {
+				_, err := sut.GetOne(id)
+				Expect(storage.IsNotFound(err)).To(BeTrue(), "GetOne(%q)", id)
+			}
+		})
+	})
+
+	Describe("GetAll", func() {
+		It("Should return empty if no item", func() {
+			data, err := sut.GetAll()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(BeEmpty())
+		})
+
+		It("Should return all items", func() {
+			sut.Insert(record("", "", 0))
+			sut.Insert(record("", "", 0))
+			sut.Insert(record("", "", 0))
+			data, _ := sut.GetAll()
+			Expect(data).To(Equal([]T{record("1", "", 1), record("2", "", 1), record("3", "", 1)}))
+		})
+	})
+
+	Describe("GetMany", func() {
+		It("Should get many successfully", func() {
+			sut.Insert(record("", "", 0))
+			sut.Insert(record("", "", 0))
+			sut.Insert(record("", "", 0))
+			data, _ := sut.GetMany([]string{"2", "3", "4"})
+			Expect(data).To(HaveLen(2))
+		})
+
+		It("Should keep the order of the IDs including repeated ones", func() {
+			sut.Insert(record("", "B1", 0))
+			sut.Insert(record("", "G", 0))
+			data, err := sut.GetMany([]string{"2", "x", "1", "2"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal([]T{record("2", "G", 1), record("1", "B1", 1), record("2", "G", 1)}))
+		})
+	})
+
+	Describe("PaginateFindAll", func() {
+		BeforeEach(func() {
+			sut.Insert(record("", "A", 0))
+			sut.Insert(record("", "B", 0))
+			sut.Insert(record("", "C", 0))
+			sut.Insert(record("", "D", 0))
+		})
+
+		It("Should show empty if give out of range params", func() {
+			len, data, _ := sut.PaginatedFindAll(0, 0)
+			Expect(len).To(Equal(4))
+			Expect(data).To(BeEmpty())
+
+			len, data, _ = sut.PaginatedFindAll(10, 10)
+			Expect(len).To(Equal(4))
+			Expect(data).To(BeEmpty())
+
+			len, data, _ = sut.PaginatedFindAllLimitOffset(0, 0)
+			Expect(len).To(Equal(4))
+			Expect(data).To(BeEmpty())
+
+			len, data, _ = sut.PaginatedFindAllLimitOffset(10, 10)
+			Expect(len).To(Equal(4))
+			Expect(data).To(BeEmpty())
+		})
+
+		It("Should treat negative params as zero", func() {
+			len, data, err := sut.PaginatedFindAllLimitOffset(2, -1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len).To(Equal(4))
+			Expect(data).To(Equal([]T{record("1", "A", 1), record("2", "B", 1)}))
+
+			len, data, _ = sut.PaginatedFindAllLimitOffset(-1, 0)
+			Expect(len).To(Equal(4))
+			Expect(data).To(BeEmpty())
+		})
+
+		It("Should paginate correctly", func() {
+			len, data, _ := sut.PaginatedFindAll(2, 3)
+			Expect(len).To(Equal(4))
+			Expect(data).To(Equal([]T{record("4", "D", 1)}))
+
+			len, data, _ = sut.PaginatedFindAllLimitOffset(2, 1)
+			Expect(len).To(Equal(4))
+			Expect(data).To(Equal([]T{record("2", "B", 1), record("3", "C", 1)}))
+
+			len, data, _ = sut.PaginatedFindAllLimitOffset(10, 3)
+			Expect(len).To(Equal(4))
+			Expect(data).To(Equal([]T{record("4", "D", 1)}))
+		})
+	})
+
+	Describe("Concurrency", func() {
+		var asyncAddAndModify = func(wg *sync.WaitGroup) {
+			defer GinkgoRecover()
+			defer wg.Done()
+
+			// insert
+			id, err := sut.Insert(record("", "", 0))
+			Expect(err).ToNot(HaveOccurred())
+
+			// then either update or delete
+			idInt, _ := strconv.ParseInt(id, 10, 64)
+			if idInt > 50 {
+				Expect(sut.Delete(id)).To(Succeed())
+			} else {
+				Expect(sut.Update(record(id, "Updated", 0))).To(Succeed())
+			}
+		}
+
+		It("Should be thread safe on writes", func() {
+			var wg sync.WaitGroup
+			wg.Add(100)
+			for i := 0; i < 100; i++ {
+				go asyncAddAndModify(&wg)
+			}
+			wg.Wait()
+
+			actual, _ := sut.GetAll()
+			Expect(actual).To(HaveLen(50))
+
+			expected := []T{}
+			for i := 1; i <= 50; i++ {
+				expected = append(expected, record(strconv.Itoa(i), "Updated", 2))
+			}
+			Expect(actual).To(Equal(expected))
+		})
+	})
+}
+
+// FloorsSpecs declares the specs for the floors relationship of the building
+// and floor storages made by factory, which share one database. The storages
+// keep FloorsIDs as given, checking and unlinking floors is left to the
+// resource.
+func FloorsSpecs(factory func() (storage.BuildingRepository, storage.FloorRepository, func())) {
+	Describe("Floors relationship", func() {
+		var (
+			buildings storage.BuildingRepository
+			floors    storage.FloorRepository
+			closeFn   func()
+		)
+
+		BeforeEach(func() {
+			buildings, floors, closeFn = factory()
+		})
+
+		AfterEach(func() {
+			closeFn()
+		})
+
+		It("Should store floors that do not exist", func() {
+			_, err := buildings.Insert(model.Building{Address: "A", FloorsIDs: []string{"1"}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(buildings.Update(model.Building{ID: "1", Address: "A", FloorsIDs: []string{"1", "2"}})).To(Succeed())
+
+			data, _ := buildings.GetOne("1")
+			Expect(data.FloorsIDs).To(Equal([]string{"1", "2"}))
+		})
+
+		It("Should keep the buildings of a deleted floor", func() {
+			floors.Insert(model.Floor{Name: "G"})
+			buildings.Insert(model.Building{Address: "A", FloorsIDs: []string{"1"}})
+			Expect(floors.Delete("1")).To(Succeed())
+
+			data, _ := buildings.GetOne("1")
+			Expect(data.FloorsIDs).To(Equal([]string{"1"}))
+			Expect(data.Version).To(Equal(1))
+		})
+	})
+}
@@ -0,0 +1,321 @@
+package storage
+
+import (
+	"encoding/gob"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Identifier is implemented by the records of a Store. The pointer to a
+// record must implement SetID too, like every api2go model does.
+type Identifier interface {
+	GetID() string
+}
+
+type idSetter interface {
+	SetID(id string) error
+}
+
+// Schema describes the records of a Store
+type Schema[T Identifier] struct {
+	// Name is used in errors, like Building
+	Name string
+	// Type names the journal files, like buildings
+	Type string
+	// FilterFields, SortFields and Fields are accepted in queries, see Query
+	FilterFields []string
+	SortFields   []string
+	Fields       []string
+	// Values returns the values of a field of c for filters and sorting
+	Values func(c T) func(field string) []string
+	// Prepare is called before c is written, old is the stored record or nil
+	// for an insert. An error rejects the write. Optional.
+	Prepare func(c T, old *T) (T, error)
+	// Version returns the version of c for DeleteVersion. Optional, records
+	// without one are deleted at any version.
+	Version func(c T) int
+	// Conflict is called with every other stored record before c is written,
+	// an error rejects the write. It runs under the write lock, so no other
+	// write can slip in between the check and the write. Optional.
+	Conflict func(c T, other T) error
+}
+
+// Store keeps records of type T in a map, optionally journaled to disk. IDs
+// are assigned in ascending order and never reused. This is thread-safe.
+type Store[T Identifier] struct {
+	schema  Schema[T]
+	data    map[string]*T
+	nextID  int
+	mutex   sync.RWMutex
+	journal *journal
+}
+
+// NewStore initializes an empty store
+func NewStore[T Identifier](schema Schema[T]) *Store[T] {
+	if _, ok := interface{}(new(T)).(idSetter); !ok {
+		panic(fmt.Sprintf("storage: %T does not implement SetID", new(T)))
+	}
+	return &Store[T]{schema: schema, data: make(map[string]*T), nextID: 1}
+}
+
+// NewStoreWithJournal restores the records saved in opts.Dir and journals
+// every following write there, see JournalOptions
+func NewStoreWithJournal[T Identifier](schema Schema[T], opts JournalOptions) (*Store[T], error) {
+	s := NewStore(schema)
+	j, err := openJournal(opts, schema.Type, s.restore, s.replay)
+	if err != nil {
+		return nil, err
+	}
+	s.journal = j
+	return s, nil
+}
+
+// Close flushes the journal, if there is one
+func (s *Store[T]) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.journal == nil {
+		return nil
+	}
+	return s.journal.Close()
+}
+
+func (s *Store[T]) restore(dec *gob.Decoder) error {
+	if err := dec.Decode(&s.nextID); err != nil {
+		return err
+	}
+	return dec.Decode(&s.data)
+}
+
+func (s *Store[T]) replay(dec *gob.Decoder) error {
+	var op byte
+	var c T
+	if err := dec.Decode(&op); err != nil {
+		return err
+	}
+	if err := dec.Decode(&c); err != nil {
+		return err
+	}
+
+	switch op {
+	case journalOpInsert, journalOpUpdate:
+		s.data[c.GetID()] = &c
+		if id, err := strconv.Atoi(c.GetID()); err == nil && id >= s.nextID {
+			s.nextID = id + 1
+		}
+	case journalOpDelete:
+		delete(s.data, c.GetID())
+	}
+	return nil
+}
+
+// write journals c before apply changes the map, must be called with the write lock held
+func (s *Store[T]) write(op byte, c T, apply func()) error {
+	if s.journal == nil {
+		apply()
+		return nil
+	}
+
+	compact, err := s.journal.append(op, c)
+	if err != nil {
+		return err
+	}
+	apply()
+
+	if compact {
+		// on failure the log still has everything, compaction is retried on the next write
+		s.journal.snapshot(s.nextID, s.data)
+	}
+	return nil
+}
+
+func (s *Store[T]) values(c T) func(field string) []string {
+	if s.schema.Values == nil {
+		return func(field string) []string {
+			if field == "id" {
+				return []string{c.GetID()}
+			}
+			return nil
+		}
+	}
+	return s.schema.Values(c)
+}
+
+// checkConflicts calls Schema.Conflict with every record but c, must be
+// called with the write lock held
+func (s *Store[T]) checkConflicts(c T) error {
+	if s.schema.Conflict == nil {
+		return nil
+	}
+	for id, other := range s.data {
+		if id == c.GetID() {
+			continue
+		}
+		if err := s.schema.Conflict(c, *other); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAll returns all records ordered by ID
+func (s *Store[T]) GetAll() ([]T, error) {
+	return s.Find(Query{})
+}
+
+// Find returns the records matching q in the order of its sort keys
+func (s *Store[T]) Find(q Query) ([]T, error) {
+	if err := q.check(s.schema.FilterFields, s.schema.SortFields, s.schema.Fields); err != nil {
+		return nil, err
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	result := []T{}
+	for i := 1; i < s.nextID; i++ {
+		data, exists := s.data[strconv.Itoa(i)]
+		if !exists || !q.match(s.values(*data)) {
+			continue
+		}
+		result = append(result, *data)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return q.less(s.values(result[i]), s.values(result[j]))
+	})
+	return result, nil
+}
+
+// PaginatedFindAll returns all records with pagination params
+func (s *Store[T]) PaginatedFindAll(page int, size int) (int, []T, error) {
+	offset := size * (page - 1)
+	limit := size
+	return s.PaginatedFindAllLimitOffset(limit, offset)
+}
+
+// PaginatedFindAllLimitOffset returns all records with paginated params limit & offset
+func (s *Store[T]) PaginatedFindAllLimitOffset(limit int, offset int) (int, []T, error) {
+	return s.PaginatedFind(Query{}, limit, offset)
+}
+
+// PaginatedFind returns the window given by limit and offset of the records matching q,
+// together with the number of all matching records
+func (s *Store[T]) PaginatedFind(q Query, limit int, offset int) (int, []T, error) {
+	all, err := s.Find(q)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	from, to := limitOffset(len(all), limit, offset)
+	return len(all), append([]T{}, all[from:to]...), nil
+}
+
+// GetOne record
+func (s *Store[T]) GetOne(id string) (T, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	data, exists := s.data[id]
+	if !exists {
+		var zero T
+		return zero, NewNotFoundError(s.schema.Name, id)
+	}
+
+	return *data, nil
+}
+
+// GetMany records by IDs in the order of ids, unknown IDs are skipped. The
+// read lock is taken once for all of them.
+func (s *Store[T]) GetMany(ids []string) ([]T, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	result := make([]T, 0, len(ids))
+	for _, id := range ids {
+		if c, exists := s.data[id]; exists {
+			result = append(result, *c)
+		}
+	}
+
+	return result, nil
+}
+
+// Insert a fresh one and return its new ID
+func (s *Store[T]) Insert(c T) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	id := strconv.Itoa(s.nextID)
+	interface{}(&c).(idSetter).SetID(id)
+	if s.schema.Prepare != nil {
+		var err error
+		if c, err = s.schema.Prepare(c, nil); err != nil {
+			return "", err
+		}
+	}
+	if err := s.checkConflicts(c); err != nil {
+		return "", err
+	}
+	err := s.write(journalOpInsert, c, func() {
+		s.data[id] = &c
+		s.nextID++
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Delete one record
+func (s *Store[T]) Delete(id string) error {
+	return s.DeleteVersion(id, 0)
+}
+
+// DeleteVersion deletes one record if it is at version, see Schema.Version.
+// Otherwise it fails with a Stale error, version 0 deletes it at any.
+func (s *Store[T]) DeleteVersion(id string, version int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	old, exists := s.data[id]
+	if !exists {
+		return NewNotFoundError(s.schema.Name, id)
+	}
+	if s.schema.Version != nil && version != 0 && s.schema.Version(*old) != version {
+		return NewStaleError(s.schema.Name, id, version, s.schema.Version(*old))
+	}
+
+	var c T
+	interface{}(&c).(idSetter).SetID(id)
+	return s.write(journalOpDelete, c, func() {
+		delete(s.data, id)
+	})
+}
+
+// Update an existing record
+func (s *Store[T]) Update(c T) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	old, exists := s.data[c.GetID()]
+	if !exists {
+		return NewNotFoundError(s.schema.Name, c.GetID())
+	}
+
+	if s.schema.Prepare != nil {
+		var err error
+		if c, err = s.schema.Prepare(c, old); err != nil {
+			return err
+		}
+	}
+	if err := s.checkConflicts(c); err != nil {
+		return err
+	}
+	return s.write(journalOpUpdate, c, func() {
+		s.data[c.GetID()] = &c
+	})
+}